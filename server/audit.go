@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mapping audit actions
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditSystemActor is the actor of changes the plugin makes on its own
+const auditSystemActor = "system"
+
+// Audit log query limits
+const (
+	defaultAuditDays  = 30
+	maxAuditDays      = 366
+	defaultAuditLimit = 200
+	maxAuditLimit     = 1000
+)
+
+// mappingAuditKeyPrefix is the key prefix of the per-day audit buckets
+const mappingAuditKeyPrefix = "gh_mapping_audit"
+
+// MappingAuditEntry records one change of one mapping
+type MappingAuditEntry struct {
+	At            string       `json:"at"` // RFC3339Nano
+	ActorID       string       `json:"actor_id"`
+	ActorUsername string       `json:"actor_username,omitempty"`
+	Source        string       `json:"source"` // api, import, suggestions, link, unlink, profile, migration or upgrade
+	Action        string       `json:"action"` // create, update or delete
+	Identity      string       `json:"identity"`
+	Before        *UserMapping `json:"before,omitempty"`
+	After         *UserMapping `json:"after,omitempty"`
+}
+
+// auditDay returns the UTC day bucket of an entry timestamp
+func auditDay(at time.Time) string {
+	return at.UTC().Format("2006-01-02")
+}
+
+// diffMappings returns the audit entries turning before into after. Mappings
+// are matched by identity and start of their period.
+func diffMappings(before, after []UserMapping) []MappingAuditEntry {
+	old := make(map[string]UserMapping, len(before))
+	for _, m := range before {
+		old[m.periodKey()] = m
+	}
+
+	var entries []MappingAuditEntry
+	seen := make(map[string]bool, len(after))
+	for _, m := range after {
+		identity := m.Identity()
+		seen[m.periodKey()] = true
+		m := m
+		prev, existed := old[m.periodKey()]
+		switch {
+		case !existed:
+			entries = append(entries, MappingAuditEntry{Action: auditCreate, Identity: identity, After: &m})
+		case prev != m:
+			entries = append(entries, MappingAuditEntry{Action: auditUpdate, Identity: identity, Before: &prev, After: &m})
+		}
+	}
+	for _, m := range before {
+		if !seen[m.periodKey()] {
+			m := m
+			entries = append(entries, MappingAuditEntry{Action: auditDelete, Identity: m.Identity(), Before: &m})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Identity < entries[j].Identity })
+	return entries
+}
+
+// recordMappingChanges appends the changes between two mapping lists to the
+// audit log. Failures are logged; the change itself has already been saved.
+func (p *Plugin) recordMappingChanges(actorID, source string, before, after []UserMapping) {
+	entries := diffMappings(before, after)
+	if len(entries) == 0 {
+		return
+	}
+
+	actorUsername := ""
+	if actorID != auditSystemActor {
+		if user, appErr := p.API.GetUser(actorID); appErr == nil {
+			actorUsername = user.Username
+		}
+	}
+	at := time.Now().UTC().Format(time.RFC3339Nano)
+	for i := range entries {
+		entries[i].At = at
+		entries[i].ActorID = actorID
+		entries[i].ActorUsername = actorUsername
+		entries[i].Source = source
+	}
+
+	if err := p.getStore().AppendMappingAudit(entries); err != nil {
+		p.API.LogError("Failed to record mapping changes", "actor_id", actorID, "count", len(entries), "error", err.Error())
+	}
+}
+
+// auditFilter selects audit entries
+type auditFilter struct {
+	Identity string // login or email, case-insensitive
+	MMUserID string // entries where the user is before or after the change
+	ActorID  string
+}
+
+func (f auditFilter) matches(e MappingAuditEntry) bool {
+	if f.Identity != "" && e.Identity != strings.ToLower(f.Identity) {
+		return false
+	}
+	if f.ActorID != "" && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.MMUserID != "" {
+		before := e.Before != nil && e.Before.MMUserID == f.MMUserID
+		after := e.After != nil && e.After.MMUserID == f.MMUserID
+		if !before && !after {
+			return false
+		}
+	}
+	return true
+}
+
+// queryMappingAudit returns matching entries between since and until, newest first
+func (p *Plugin) queryMappingAudit(filter auditFilter, since, until time.Time, limit int) ([]MappingAuditEntry, error) {
+	entries := make([]MappingAuditEntry, 0)
+	for day := until.UTC(); !day.Before(since.UTC().Truncate(24 * time.Hour)); day = day.AddDate(0, 0, -1) {
+		dayEntries, err := p.getStore().GetMappingAudit(auditDay(day))
+		if err != nil {
+			return nil, err
+		}
+		for i := len(dayEntries) - 1; i >= 0; i-- {
+			e := dayEntries[i]
+			at, err := time.Parse(time.RFC3339Nano, e.At)
+			if err != nil || at.Before(since) || at.After(until) || !filter.matches(e) {
+				continue
+			}
+			entries = append(entries, e)
+			if len(entries) >= limit {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+// handleGetMappingAudit returns the mapping change history (admin only).
+// Query: identity, mm_user_id, actor_id, since and until (RFC3339 or YYYY-MM-DD), limit.
+func (p *Plugin) handleGetMappingAudit(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+
+	until := time.Now()
+	if v := query.Get("until"); v != "" {
+		t, err := parseAuditTime(v, true)
+		if err != nil {
+			http.Error(w, `{"error": "invalid until"}`, http.StatusBadRequest)
+			return
+		}
+		until = t
+	}
+	since := until.AddDate(0, 0, -defaultAuditDays)
+	if v := query.Get("since"); v != "" {
+		t, err := parseAuditTime(v, false)
+		if err != nil {
+			http.Error(w, `{"error": "invalid since"}`, http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	if since.After(until) || until.Sub(since) > maxAuditDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf(`{"error": "since must be before until and at most %d days earlier"}`, maxAuditDays), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultAuditLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error": "invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	filter := auditFilter{
+		Identity: strings.TrimPrefix(query.Get("identity"), "@"),
+		MMUserID: query.Get("mm_user_id"),
+		ActorID:  query.Get("actor_id"),
+	}
+	entries, err := p.queryMappingAudit(filter, since, until, limit)
+	if err != nil {
+		p.API.LogError("Failed to read mapping audit log", "error", err.Error())
+		http.Error(w, `{"error": "failed to read audit log"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
+
+// parseAuditTime accepts RFC3339 or a date; a date as upper bound covers the whole day
+func parseAuditTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
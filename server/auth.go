@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+)
+
+// isSystemAdmin reports whether the MM user is a system admin
+func (p *Plugin) isSystemAdmin(userID string) bool {
+	user, err := p.API.GetUser(userID)
+	if err != nil {
+		return false
+	}
+	return user.IsSystemAdmin()
+}
+
+// requireSystemAdmin writes an error response and returns false unless the
+// requesting user is a system admin
+func (p *Plugin) requireSystemAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userID := r.Header.Get("Mattermost-User-Id")
+
+	// Check if user is admin
+	user, err := p.API.GetUser(userID)
+	if err != nil {
+		http.Error(w, `{"error": "failed to get user"}`, http.StatusInternalServerError)
+		return false
+	}
+	if !user.IsSystemAdmin() {
+		http.Error(w, `{"error": "admin only"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
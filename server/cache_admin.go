@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	statsKeyPrefix     = "gh_stats:"
+	backfillStatusKey  = "cache_backfill_status"
+	backfillLockKey    = "cache_backfill_lock"
+	kvListPageSize     = 200
+	maxRefreshWeekSpan = 12 // synchronous refresh limit, use backfill for more
+
+	// A running backfill that hasn't reported progress for this long is
+	// assumed to have died with its node
+	backfillStaleAfter = 10 * time.Minute
+)
+
+var isoWeekPattern = regexp.MustCompile(`^\d{4}-W\d{2}$`)
+
+// CachedWeek describes a cached repo+week entry
+type CachedWeek struct {
+	Key           string `json:"key"`
+	Repo          string `json:"repo"`
+	Week          string `json:"week"`
+	Users         int    `json:"users"`
+	Commits       int    `json:"commits"`
+	FetchedAt     string `json:"fetched_at"`
+	SchemaVersion int    `json:"schema_version"`
+	Outdated      bool   `json:"outdated"`
+	Status        string `json:"status"`
+}
+
+// BackfillStatus reports progress of a background backfill
+type BackfillStatus struct {
+	Running    bool     `json:"running"`
+	Repos      []string `json:"repos"`
+	WeekStart  string   `json:"week_start"`
+	WeekEnd    string   `json:"week_end"`
+	Force      bool     `json:"force"`
+	Total      int      `json:"total"`
+	Done       int      `json:"done"`
+	Skipped    int      `json:"skipped"`
+	Failed     int      `json:"failed"`
+	StartedBy  string   `json:"started_by"`
+	StartedAt  string   `json:"started_at"`
+	UpdatedAt  string   `json:"updated_at"`
+	FinishedAt string   `json:"finished_at,omitempty"`
+}
+
+// cacheRangeRequest selects repo-weeks for purge, refresh and backfill.
+// An empty repo selects all tracked repositories.
+type cacheRangeRequest struct {
+	Repo      string `json:"repo"`
+	WeekStart string `json:"week_start"`
+	WeekEnd   string `json:"week_end"`
+	Force     bool   `json:"force"` // backfill only: refetch weeks that are already cached
+}
+
+// resolve validates the request and returns the selected repos and weeks
+func (req cacheRangeRequest) resolve(p *Plugin, config *configuration) ([]string, []string, error) {
+	if !isoWeekPattern.MatchString(req.WeekStart) || !isoWeekPattern.MatchString(req.WeekEnd) {
+		return nil, nil, fmt.Errorf("week_start and week_end must be ISO weeks like 2026-W05")
+	}
+	if req.WeekStart > req.WeekEnd {
+		return nil, nil, fmt.Errorf("week_start is after week_end")
+	}
+
+	repos := trackedRepos(config)
+	if req.Repo != "" && req.Repo != "all" {
+		repo, tracked := findTrackedRepo(config, req.Repo)
+		if !tracked {
+			return nil, nil, fmt.Errorf("repository %s is not tracked", req.Repo)
+		}
+		repos = []string{repo}
+	}
+
+	return repos, p.getWeeksInRange(req.WeekStart, req.WeekEnd), nil
+}
+
+// handleCacheRoute dispatches the admin cache endpoints under /api/v1/admin/cache
+func (p *Plugin) handleCacheRoute(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/cache"), "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		p.handleListCache(w, r)
+	case action == "purge" && r.Method == http.MethodPost:
+		p.handlePurgeCache(w, r)
+	case action == "purge-repo" && r.Method == http.MethodPost:
+		p.handlePurgeRepo(w, r)
+	case action == "refresh" && r.Method == http.MethodPost:
+		p.handleRefreshCache(w, r)
+	case action == "backfill" && r.Method == http.MethodPost:
+		p.handleStartBackfill(w, r)
+	case action == "backfill" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(p.getBackfillStatus())
+	case action == "migrate" && r.Method == http.MethodPost:
+		p.handleMigrateCache(w, r)
+	case action == "rebuild" && r.Method == http.MethodPost:
+		p.handleRebuildCache(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleListCache lists cached repo-weeks, optionally filtered by repo and week range
+func (p *Plugin) handleListCache(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	entries, err := p.listCachedWeeks(query.Get("repo"), query.Get("week_start"), query.Get("week_end"))
+	if err != nil {
+		http.Error(w, `{"error": "failed to list cache"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (p *Plugin) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	var req cacheRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	repos, weeks, err := req.resolve(p, p.getConfiguration())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	purged := p.purgeCachedWeeks(repos, weeks)
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+// handlePurgeRepo deletes everything stored for a repo, tracked or not
+func (p *Plugin) handlePurgeRepo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Repo string `json:"repo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Repo == "" {
+		http.Error(w, `{"error": "repo is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := p.purgeRepo(req.Repo)
+	if err != nil {
+		p.API.LogError("Failed to purge repo", "repo", req.Repo, "error", err.Error())
+		http.Error(w, `{"error": "failed to purge repo"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
+
+func (p *Plugin) handleRefreshCache(w http.ResponseWriter, r *http.Request) {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req cacheRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	repos, weeks, err := req.resolve(p, config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(weeks) > maxRefreshWeekSpan {
+		http.Error(w, fmt.Sprintf(`{"error": "refresh is limited to %d weeks, use backfill"}`, maxRefreshWeekSpan), http.StatusBadRequest)
+		return
+	}
+
+	refreshed, failed := p.refreshCachedWeeks(repos, weeks, config.GitHubToken)
+	json.NewEncoder(w).Encode(map[string]int{"refreshed": refreshed, "failed": failed})
+}
+
+func (p *Plugin) handleStartBackfill(w http.ResponseWriter, r *http.Request) {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req cacheRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	repos, weeks, err := req.resolve(p, config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	status, err := p.startBackfill(repos, weeks, req.Force, r.Header.Get("Mattermost-User-Id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleRebuildCache recomputes cached weeks from the local commit store
+func (p *Plugin) handleRebuildCache(w http.ResponseWriter, r *http.Request) {
+	var req cacheRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	repos, weeks, err := req.resolve(p, p.getConfiguration())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	rebuilt, missing := p.rebuildCachedWeeks(repos, weeks)
+	json.NewEncoder(w).Encode(map[string]int{"rebuilt": rebuilt, "missing": missing})
+}
+
+// rebuildCachedWeeks recomputes past weeks from stored commits, returning how many
+// were rebuilt and how many had no stored commits
+func (p *Plugin) rebuildCachedWeeks(repos, weeks []string) (int, int) {
+	currentWeek := currentISOWeek()
+	rebuilt, missing := 0, 0
+	for _, repo := range repos {
+		for _, week := range weeks {
+			if week >= currentWeek {
+				continue
+			}
+			stats := p.rebuildWeeklyStats(repo, week)
+			if stats == nil {
+				missing++
+				continue
+			}
+			p.cacheWeeklyStats(stats)
+			rebuilt++
+		}
+	}
+	return rebuilt, missing
+}
+
+// listCachedWeeks scans the KV store for cached weekly stats
+func (p *Plugin) listCachedWeeks(repo, weekStart, weekEnd string) ([]CachedWeek, error) {
+	entries := make([]CachedWeek, 0)
+	err := p.scanCachedWeeks(func(key string, stats *WeeklyRepoStats) bool {
+		if repo != "" && !strings.EqualFold(stats.Repo, repo) {
+			return true
+		}
+		if (weekStart != "" && stats.Week < weekStart) || (weekEnd != "" && stats.Week > weekEnd) {
+			return true
+		}
+
+		entry := CachedWeek{
+			Key:           key,
+			Repo:          stats.Repo,
+			Week:          stats.Week,
+			Users:         len(stats.Users),
+			FetchedAt:     stats.FetchedAt,
+			SchemaVersion: stats.SchemaVersion,
+			Outdated:      stats.Outdated(),
+			Status:        stats.Status,
+		}
+		for _, u := range stats.Users {
+			entry.Commits += u.Commits
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Repo != entries[j].Repo {
+			return entries[i].Repo < entries[j].Repo
+		}
+		return entries[i].Week < entries[j].Week
+	})
+	return entries, nil
+}
+
+// scanCachedWeeks calls fn for every cached weekly stats entry until fn returns false
+func (p *Plugin) scanCachedWeeks(fn func(key string, stats *WeeklyRepoStats) bool) error {
+	return p.getStore().ScanWeeklyStats(fn)
+}
+
+// purgeCachedWeeks deletes cached stats and patches, returning the number of stats entries removed
+func (p *Plugin) purgeCachedWeeks(repos, weeks []string) int {
+	store := p.getStore()
+	purged := 0
+	for _, repo := range repos {
+		for _, week := range weeks {
+			if p.loadCachedWeeklyStats(repo, week) != nil {
+				purged++
+			}
+			if err := store.DeleteWeeklyStats(repo, week); err != nil {
+				p.API.LogWarn("Failed to purge cached stats", "repo", repo, "week", week, "error", err.Error())
+			}
+			if err := p.API.KVDelete(repoWeekKey("gh_patches", repo, week)); err != nil {
+				p.API.LogWarn("Failed to purge cached patches", "repo", repo, "week", week, "error", err.Error())
+			}
+			if err := store.DeleteCurrentWeek(repo, week); err != nil {
+				p.API.LogWarn("Failed to purge current week snapshot", "repo", repo, "week", week, "error", err.Error())
+			}
+			p.purgeCommitRecords(repo, week)
+
+			// Fetch metadata is kept, so the week's key index stays
+			purgedKeys := []string{repoWeekKey("gh_stats", repo, week), repoWeekKey("gh_patches", repo, week), repoWeekKey("gh_current", repo, week), repoWeekKey(commitWeekIndexPrefix, repo, week)}
+			if err := unindexRepoKeys(p.API, repo, week, purgedKeys...); err != nil {
+				p.API.LogWarn("Failed to update key index", "repo", repo, "week", week, "error", err.Error())
+			}
+		}
+	}
+	return purged
+}
+
+// refreshCachedWeeks refetches repo-weeks from GitHub, replacing cached entries
+func (p *Plugin) refreshCachedWeeks(repos, weeks []string, token string) (int, int) {
+	currentWeek := currentISOWeek()
+	refreshed, failed := 0, 0
+	for _, repo := range repos {
+		for _, week := range weeks {
+			if week > currentWeek {
+				continue
+			}
+			if p.refreshWeeklyStats(repo, week, week == currentWeek, token) == nil {
+				failed++
+				continue
+			}
+			refreshed++
+		}
+	}
+	return refreshed, failed
+}
+
+// startBackfill fetches the selected repo-weeks in the background.
+// Weeks that are already cached are skipped unless force is set. A cluster
+// mutex keeps two nodes from starting a backfill at the same time. The
+// returned status is a snapshot; the backfill goroutine updates its own copy.
+func (p *Plugin) startBackfill(repos, weeks []string, force bool, userID string) (*BackfillStatus, error) {
+	mutex, err := cluster.NewMutex(p.API, backfillLockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backfill lock: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("a backfill is already starting")
+	}
+	defer mutex.Unlock()
+
+	if current := p.getBackfillStatus(); current != nil && current.Running {
+		updatedAt, _ := time.Parse(time.RFC3339, current.UpdatedAt)
+		if time.Since(updatedAt) < backfillStaleAfter {
+			return nil, fmt.Errorf("a backfill is already running")
+		}
+	}
+
+	status := &BackfillStatus{
+		Running:   true,
+		Repos:     repos,
+		WeekStart: weeks[0],
+		WeekEnd:   weeks[len(weeks)-1],
+		Force:     force,
+		Total:     len(repos) * len(weeks),
+		StartedBy: userID,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+	p.saveBackfillStatus(status)
+
+	snapshot := *status
+	go p.runBackfill(status, weeks)
+
+	return &snapshot, nil
+}
+
+func (p *Plugin) runBackfill(status *BackfillStatus, weeks []string) {
+	currentWeek := currentISOWeek()
+
+	for _, repo := range status.Repos {
+		for _, week := range weeks {
+			token := p.getConfiguration().GitHubToken
+			switch {
+			case week > currentWeek:
+				status.Skipped++
+			case !status.Force && p.isCachedUpToDate(repo, week):
+				status.Skipped++
+			case p.refreshWeeklyStats(repo, week, week == currentWeek, token) == nil:
+				status.Failed++
+			}
+			status.Done++
+			p.saveBackfillStatus(status)
+		}
+	}
+
+	status.Running = false
+	status.FinishedAt = time.Now().Format(time.RFC3339)
+	p.saveBackfillStatus(status)
+
+	p.API.LogInfo("Cache backfill finished",
+		"total", status.Total, "skipped", status.Skipped, "failed", status.Failed)
+}
+
+// saveBackfillStatus stores progress in KV so every cluster node can report it
+func (p *Plugin) saveBackfillStatus(status *BackfillStatus) {
+	status.UpdatedAt = time.Now().Format(time.RFC3339)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	if err := p.API.KVSet(backfillStatusKey, data); err != nil {
+		p.API.LogWarn("Failed to save backfill status", "error", err.Error())
+	}
+}
+
+// getBackfillStatus returns the last backfill status, or nil if none was run
+func (p *Plugin) getBackfillStatus() *BackfillStatus {
+	data, err := p.API.KVGet(backfillStatusKey)
+	if err != nil || data == nil {
+		return nil
+	}
+	var status BackfillStatus
+	if json.Unmarshal(data, &status) != nil {
+		return nil
+	}
+	return &status
+}
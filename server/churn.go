@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultChurnWindowDays = 21
+
+// WeeklyPatches stores per-file line hashes of the commits of a repo+week,
+// used to detect lines that were rewritten shortly after being added
+type WeeklyPatches struct {
+	Week    string        `json:"week"`
+	Repo    string        `json:"repo"`
+	Commits []PatchCommit `json:"commits"`
+}
+
+type PatchCommit struct {
+	SHA    string      `json:"sha"`
+	Author string      `json:"author"` // github login
+	Date   string      `json:"date"`   // RFC3339 author date
+	Files  []PatchFile `json:"files"`
+}
+
+type PatchFile struct {
+	Path         string   `json:"path"`
+	PreviousPath string   `json:"previous_path,omitempty"` // set if the file was renamed
+	Added        []uint32 `json:"added"`                   // hashes of added lines
+	Removed      []uint32 `json:"removed"`                 // hashes of removed lines
+}
+
+// ChurnStat counts added lines and how many of them were rewritten within the window
+type ChurnStat struct {
+	Added   int `json:"added"`
+	Churned int `json:"churned"`
+}
+
+// Rate returns the share of added lines that were churned
+func (c ChurnStat) Rate() float64 {
+	if c.Added == 0 {
+		return 0
+	}
+	return float64(c.Churned) / float64(c.Added)
+}
+
+// hashPatchLines hashes the added and removed lines of a unified diff patch.
+// Blank and single-character lines (braces etc.) are skipped as they match too easily.
+func hashPatchLines(patch string) ([]uint32, []uint32) {
+	var added, removed []uint32
+	for _, line := range strings.Split(patch, "\n") {
+		if line == "" || (line[0] != '+' && line[0] != '-') {
+			continue
+		}
+		content := strings.TrimSpace(line[1:])
+		if len(content) <= 1 {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(content))
+		if line[0] == '+' {
+			added = append(added, h.Sum32())
+		} else {
+			removed = append(removed, h.Sum32())
+		}
+	}
+	return added, removed
+}
+
+func (p *Plugin) saveWeeklyPatches(patches *WeeklyPatches) {
+	if patches == nil || len(patches.Commits) == 0 {
+		return
+	}
+	data, err := json.Marshal(patches)
+	if err != nil {
+		return
+	}
+	key := repoWeekKey("gh_patches", patches.Repo, patches.Week)
+	if err := p.API.KVSet(key, data); err != nil {
+		p.API.LogWarn("Failed to save patches", "repo", patches.Repo, "week", patches.Week, "error", err.Error())
+		return
+	}
+	if err := indexRepoKey(p.API, patches.Repo, key); err != nil {
+		p.API.LogWarn("Failed to index patches key", "repo", patches.Repo, "week", patches.Week, "error", err.Error())
+	}
+}
+
+func (p *Plugin) loadWeeklyPatches(repo, week string) *WeeklyPatches {
+	data, err := p.API.KVGet(repoWeekKey("gh_patches", repo, week))
+	if err != nil || data == nil {
+		return nil
+	}
+	var patches WeeklyPatches
+	if json.Unmarshal(data, &patches) != nil {
+		return nil
+	}
+	return &patches
+}
+
+// churnWindowDays returns the configured churn window, falling back to the default
+func churnWindowDays(config *configuration) int {
+	if config.ChurnWindowDays > 0 {
+		return config.ChurnWindowDays
+	}
+	return defaultChurnWindowDays
+}
+
+// churnForRepo computes churn for lines added in the given weeks, per stats
+// owner: the MM user an author was mapped to when adding a line, or the login.
+// Patches of the following weeks within the window are read too, so lines added
+// near the end of the range can still be found rewritten.
+func (p *Plugin) churnForRepo(repo string, weeks []string, windowDays int, mappings mappingIndex) map[string]ChurnStat {
+	if len(weeks) == 0 {
+		return nil
+	}
+
+	var commits []PatchCommit
+	var counted []bool
+	load := func(week string, count bool) {
+		patches := p.loadWeeklyPatches(repo, week)
+		if patches == nil {
+			return
+		}
+		for _, c := range patches.Commits {
+			// Lines belong to whoever the author was mapped to when adding them
+			at, _ := time.Parse(time.RFC3339, c.Date)
+			c.Author = mappings.ownerAt(c.Author, at)
+			commits = append(commits, c)
+			counted = append(counted, count)
+		}
+	}
+
+	for _, week := range weeks {
+		load(week, true)
+	}
+	currentWeek := currentISOWeek()
+	next := weeks[len(weeks)-1]
+	for i := 0; i < (windowDays+6)/7; i++ {
+		next = p.nextWeek(next)
+		if next > currentWeek {
+			break
+		}
+		load(next, false)
+	}
+
+	return computeChurn(commits, counted, time.Duration(windowDays)*24*time.Hour)
+}
+
+// churnAddition is a line still eligible for churn detection
+type churnAddition struct {
+	author  string
+	at      time.Time
+	counted bool
+}
+
+// computeChurn replays commits in date order. A removed line that matches a line
+// added to the same file within the window marks that addition as churned.
+// Renamed files keep the additions made under their previous path. Only
+// additions from counted commits contribute to the result.
+func computeChurn(commits []PatchCommit, counted []bool, window time.Duration) map[string]ChurnStat {
+	type entry struct {
+		commit  PatchCommit
+		at      time.Time
+		counted bool
+	}
+	entries := make([]entry, 0, len(commits))
+	for i, c := range commits {
+		at, err := time.Parse(time.RFC3339, c.Date)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{commit: c, at: at, counted: counted[i]})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].at.Before(entries[j].at)
+	})
+
+	result := make(map[string]ChurnStat)
+	// file path -> line hash -> outstanding additions, oldest first
+	outstanding := make(map[string]map[uint32][]churnAddition)
+
+	for _, e := range entries {
+		for _, f := range e.commit.Files {
+			if f.PreviousPath != "" && f.PreviousPath != f.Path {
+				moveOutstanding(outstanding, f.PreviousPath, f.Path)
+			}
+			lines := outstanding[f.Path]
+			if lines == nil {
+				lines = make(map[uint32][]churnAddition)
+				outstanding[f.Path] = lines
+			}
+
+			for _, h := range f.Removed {
+				queue := lines[h]
+				// Drop additions that fell out of the window
+				for len(queue) > 0 && e.at.Sub(queue[0].at) > window {
+					queue = queue[1:]
+				}
+				if len(queue) > 0 {
+					if add := queue[0]; add.counted {
+						stat := result[add.author]
+						stat.Churned++
+						result[add.author] = stat
+					}
+					queue = queue[1:]
+				}
+				lines[h] = queue
+			}
+
+			for _, h := range f.Added {
+				lines[h] = append(lines[h], churnAddition{author: e.commit.Author, at: e.at, counted: e.counted})
+				if e.counted {
+					stat := result[e.commit.Author]
+					stat.Added++
+					result[e.commit.Author] = stat
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// moveOutstanding moves the outstanding additions of a renamed file to its new path
+func moveOutstanding(outstanding map[string]map[uint32][]churnAddition, from, to string) {
+	moved := outstanding[from]
+	if moved == nil {
+		return
+	}
+	delete(outstanding, from)
+	lines := outstanding[to]
+	if lines == nil {
+		outstanding[to] = moved
+		return
+	}
+	for h, queue := range moved {
+		merged := append(lines[h], queue...)
+		sort.SliceStable(merged, func(i, j int) bool { return merged[i].at.Before(merged[j].at) })
+		lines[h] = merged
+	}
+}
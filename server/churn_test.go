@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeChurn(t *testing.T) {
+	const window = 21 * 24 * time.Hour
+	commit := func(author, date string, files ...PatchFile) PatchCommit {
+		return PatchCommit{SHA: author + date, Author: author, Date: date, Files: files}
+	}
+	added := func(path string, lines ...uint32) PatchFile { return PatchFile{Path: path, Added: lines} }
+	removed := func(path string, lines ...uint32) PatchFile { return PatchFile{Path: path, Removed: lines} }
+
+	tests := []struct {
+		name    string
+		commits []PatchCommit
+		counted []bool // all counted if nil
+		want    map[string]ChurnStat
+	}{
+		{
+			name: "removed inside the window",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1, 2, 3)),
+				commit("bob", "2025-03-10T10:00:00Z", removed("a.go", 1, 2)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 3, Churned: 2}},
+		},
+		{
+			name: "removed outside the window",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1, 2, 3)),
+				commit("bob", "2025-03-25T10:00:00Z", removed("a.go", 1, 2)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 3}},
+		},
+		{
+			name: "replayed in date order",
+			commits: []PatchCommit{
+				commit("bob", "2025-03-10T10:00:00Z", removed("a.go", 1)),
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 1, Churned: 1}},
+		},
+		{
+			name: "same line removed from another file",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1)),
+				commit("alice", "2025-03-04T10:00:00Z", removed("b.go", 1)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 1}},
+		},
+		{
+			name: "each removal churns one addition",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1)),
+				commit("bob", "2025-03-04T10:00:00Z", added("a.go", 1)),
+				commit("carol", "2025-03-05T10:00:00Z", removed("a.go", 1)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 1, Churned: 1}, "bob": {Added: 1}},
+		},
+		{
+			name: "removed after a rename",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1, 2)),
+				commit("bob", "2025-03-04T10:00:00Z", PatchFile{Path: "b.go", PreviousPath: "a.go"}),
+				commit("bob", "2025-03-05T10:00:00Z", removed("b.go", 1), removed("a.go", 2)),
+			},
+			want: map[string]ChurnStat{"alice": {Added: 2, Churned: 1}},
+		},
+		{
+			name: "additions of commits that aren't counted",
+			commits: []PatchCommit{
+				commit("alice", "2025-03-03T10:00:00Z", added("a.go", 1)),
+				commit("bob", "2025-03-10T10:00:00Z", added("a.go", 2), removed("a.go", 1)),
+				commit("alice", "2025-03-11T10:00:00Z", removed("a.go", 2)),
+			},
+			counted: []bool{true, false, false},
+			want:    map[string]ChurnStat{"alice": {Added: 1, Churned: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counted := tt.counted
+			if counted == nil {
+				counted = make([]bool, len(tt.commits))
+				for i := range counted {
+					counted[i] = true
+				}
+			}
+			assert.Equal(t, tt.want, computeChurn(tt.commits, counted, window))
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const commandTrigger = "github-reports"
+
+const linkCommandHelp = "###### GitHub Reports account commands\n" +
+	"- `/github-reports link` - Link your GitHub account by signing in to GitHub\n" +
+	"- `/github-reports unlink [login]` - Unlink one or all of your GitHub accounts\n"
+
+const cacheCommandHelp = "###### GitHub Reports cache commands\n" +
+	"- `/github-reports cache list [repo] [week_start] [week_end]` - List cached repo-weeks\n" +
+	"- `/github-reports cache purge <repo|all> <week_start> <week_end>` - Delete cached weeks\n" +
+	"- `/github-reports cache purge-repo <owner/repo>` - Delete everything stored for a repo, tracked or not\n" +
+	"- `/github-reports cache refresh <repo|all> <week_start> <week_end>` - Refetch weeks now\n" +
+	"- `/github-reports cache backfill <repo|all> <week_start> <week_end> [force]` - Fetch weeks in the background\n" +
+	"- `/github-reports cache status` - Show backfill progress\n" +
+	"- `/github-reports cache migrate` - Refetch weeks cached by an older plugin version\n" +
+	"- `/github-reports cache rebuild <repo|all> <week_start> <week_end>` - Recompute weeks from stored commits\n"
+
+func getCommand() *model.Command {
+	autocomplete := model.NewAutocompleteData(commandTrigger, "[command]", "GitHub Activity Reports commands")
+
+	cache := model.NewAutocompleteData("cache", "[list|purge|purge-repo|refresh|backfill|status|migrate|rebuild]", "Manage cached GitHub stats (admin only)")
+	for _, sub := range []struct{ name, hint, help string }{
+		{"list", "[repo] [week_start] [week_end]", "List cached repo-weeks"},
+		{"purge", "<repo|all> <week_start> <week_end>", "Delete cached weeks"},
+		{"purge-repo", "<owner/repo>", "Delete everything stored for a repo"},
+		{"refresh", "<repo|all> <week_start> <week_end>", "Refetch weeks now"},
+		{"backfill", "<repo|all> <week_start> <week_end> [force]", "Fetch weeks in the background"},
+		{"status", "", "Show backfill progress"},
+		{"migrate", "", "Refetch weeks cached by an older plugin version"},
+		{"rebuild", "<repo|all> <week_start> <week_end>", "Recompute weeks from stored commits"},
+	} {
+		cache.AddCommand(model.NewAutocompleteData(sub.name, sub.hint, sub.help))
+	}
+	autocomplete.AddCommand(cache)
+	autocomplete.AddCommand(model.NewAutocompleteData("link", "", "Link your GitHub account"))
+	autocomplete.AddCommand(model.NewAutocompleteData("unlink", "[login]", "Unlink your GitHub accounts"))
+
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "GitHub Reports",
+		Description:      "GitHub Activity Reports commands",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: link, unlink, cache",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: autocomplete,
+	}
+}
+
+// ExecuteCommand handles the /github-reports slash command
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return ephemeralResponse(linkCommandHelp + cacheCommandHelp), nil
+	}
+
+	switch fields[1] {
+	case "link":
+		return ephemeralResponse(p.startLink(args.UserId, args.ChannelId)), nil
+	case "unlink":
+		login := ""
+		if len(fields) > 2 {
+			login = fields[2]
+		}
+		removed, err := p.unlinkAccounts(args.UserId, login)
+		if err != nil {
+			return ephemeralResponse("Failed to unlink: " + err.Error()), nil
+		}
+		if len(removed) == 0 {
+			return ephemeralResponse("No linked GitHub account found."), nil
+		}
+		return ephemeralResponse("Unlinked " + strings.Join(removed, ", ") + "."), nil
+	case "cache":
+		if !p.isSystemAdmin(args.UserId) {
+			return ephemeralResponse("Only system admins can manage the cache."), nil
+		}
+		return ephemeralResponse(p.executeCacheCommand(args.UserId, fields[2:])), nil
+	default:
+		return ephemeralResponse(linkCommandHelp + cacheCommandHelp), nil
+	}
+}
+
+// executeCacheCommand runs a cache subcommand and returns the reply text
+func (p *Plugin) executeCacheCommand(userID string, args []string) string {
+	if len(args) == 0 {
+		return cacheCommandHelp
+	}
+
+	config := p.getConfiguration()
+	action, params := args[0], args[1:]
+
+	switch action {
+	case "list":
+		var repo, weekStart, weekEnd string
+		if len(params) > 0 && params[0] != "all" {
+			repo = params[0]
+		}
+		if len(params) > 2 {
+			weekStart, weekEnd = params[1], params[2]
+		}
+		entries, err := p.listCachedWeeks(repo, weekStart, weekEnd)
+		if err != nil {
+			return "Failed to list cache: " + err.Error()
+		}
+		if len(entries) == 0 {
+			return "No cached weeks found."
+		}
+		var sb strings.Builder
+		sb.WriteString("| Repository | Week | Status | Users | Commits | Fetched |\n|---|---|---|---|---|---|\n")
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "| %s | %s | %s | %d | %d | %s |\n", e.Repo, e.Week, e.Status, e.Users, e.Commits, e.FetchedAt)
+		}
+		return sb.String()
+
+	case "status":
+		status := p.getBackfillStatus()
+		if status == nil {
+			return "No backfill has been run."
+		}
+		return formatBackfillStatus(status)
+
+	case "purge-repo":
+		if len(params) < 1 {
+			return cacheCommandHelp
+		}
+		deleted, err := p.purgeRepo(params[0])
+		if err != nil {
+			return "Failed to purge repo: " + err.Error()
+		}
+		return fmt.Sprintf("Deleted %d keys of %s.", deleted, params[0])
+
+	case "migrate":
+		if !hasStatsSource(config) {
+			return "GitHub token or local repositories not configured."
+		}
+		if err := p.startMigration(); err != nil {
+			return err.Error()
+		}
+		return "Migration of outdated cached weeks started."
+
+	case "purge", "refresh", "backfill", "rebuild":
+		if len(params) < 3 {
+			return cacheCommandHelp
+		}
+		req := cacheRangeRequest{
+			Repo:      params[0],
+			WeekStart: params[1],
+			WeekEnd:   params[2],
+			Force:     len(params) > 3 && params[3] == "force",
+		}
+		repos, weeks, err := req.resolve(p, config)
+		if err != nil {
+			return err.Error()
+		}
+		if action != "purge" && action != "rebuild" && !hasStatsSource(config) {
+			return "GitHub token or local repositories not configured."
+		}
+
+		switch action {
+		case "purge":
+			return fmt.Sprintf("Purged %d cached weeks.", p.purgeCachedWeeks(repos, weeks))
+		case "rebuild":
+			rebuilt, missing := p.rebuildCachedWeeks(repos, weeks)
+			return fmt.Sprintf("Rebuilt %d weeks, %d had no stored commits.", rebuilt, missing)
+		case "refresh":
+			if len(weeks) > maxRefreshWeekSpan {
+				return fmt.Sprintf("Refresh is limited to %d weeks, use backfill instead.", maxRefreshWeekSpan)
+			}
+			refreshed, failed := p.refreshCachedWeeks(repos, weeks, config.GitHubToken)
+			return fmt.Sprintf("Refreshed %d weeks, %d failed.", refreshed, failed)
+		default:
+			status, err := p.startBackfill(repos, weeks, req.Force, userID)
+			if err != nil {
+				return err.Error()
+			}
+			return "Backfill started. " + formatBackfillStatus(status)
+		}
+
+	default:
+		return cacheCommandHelp
+	}
+}
+
+func formatBackfillStatus(status *BackfillStatus) string {
+	state := "finished"
+	if status.Running {
+		state = "running"
+	}
+	return fmt.Sprintf("Backfill %s: %d/%d weeks processed (%d skipped, %d failed) for %s, %s to %s.",
+		state, status.Done, status.Total, status.Skipped, status.Failed,
+		strings.Join(status.Repos, ", "), status.WeekStart, status.WeekEnd)
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultCommitQueryLimit = 100
+	maxCommitQueryLimit     = 1000
+)
+
+// CommitRecord is a normalized commit kept in plugin storage for ad-hoc queries
+type CommitRecord struct {
+	SHA         string       `json:"sha"`
+	Repo        string       `json:"repo"`
+	Author      string       `json:"author"` // github login, or the author email if not linked to an account
+	AuthorName  string       `json:"author_name"`
+	AuthorEmail string       `json:"author_email"`
+	AuthoredAt  string       `json:"authored_at"`
+	CommittedAt string       `json:"committed_at"`
+	Message     string       `json:"message"`
+	HasDetails  bool         `json:"has_details"` // line counts and files were fetched
+	Added       int          `json:"added"`
+	Removed     int          `json:"removed"`
+	Files       []CommitFile `json:"files,omitempty"`
+}
+
+type CommitFile struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// CommitWeekIndex lists the commits stored for a repo+week along with the
+// completeness of the fetch that produced them
+type CommitWeekIndex struct {
+	Repo           string   `json:"repo"`
+	Week           string   `json:"week"`
+	SHAs           []string `json:"shas"`
+	Status         string   `json:"status"`
+	MissingDetails int      `json:"missing_details"`
+	FailedDetails  int      `json:"failed_details,omitempty"`
+	PageLimit      bool     `json:"page_limit,omitempty"`
+	PageFailed     bool     `json:"page_failed,omitempty"`
+	FetchedAt      string   `json:"fetched_at"`
+	// Authors with an author index entry for the week; nil in indexes written
+	// before they were listed
+	Authors []string `json:"authors"`
+	// Commits stored without line counts; nil in indexes written before they
+	// were listed
+	Undetailed []string `json:"undetailed"`
+}
+
+// commitWeekIndexPrefix is the key prefix of the per repo-week commit indexes
+const commitWeekIndexPrefix = "gh_cidx_week"
+
+func commitRecordKey(repo, sha string) string {
+	return repoWeekKey("gh_commit", repo, sha)
+}
+
+// authorIndexKey indexes an author's commits per week across repos. Logins of
+// local clones may be long emails, so they are hashed like repo names.
+func authorIndexKey(login, week string) string {
+	return "gh_cidx_author:" + keyHash(login) + ":" + week
+}
+
+// saveCommitRecords stores the records of a repo+week and updates the repo-week
+// and author indexes. A commit doesn't change, so only records that are new or
+// gain their line counts are written. Records and author entries of an earlier
+// fetch that are gone from this one are removed.
+func (p *Plugin) saveCommitRecords(repo, week string, records []CommitRecord, stats *WeeklyRepoStats) {
+	previous := p.loadCommitWeekIndex(repo, week)
+	index := CommitWeekIndex{
+		Repo:           repo,
+		Week:           week,
+		SHAs:           make([]string, 0, len(records)),
+		Status:         stats.Status,
+		MissingDetails: stats.MissingDetails,
+		FailedDetails:  stats.FailedDetails,
+		PageLimit:      stats.PageLimit,
+		PageFailed:     stats.PageFailed,
+		FetchedAt:      stats.FetchedAt,
+	}
+	byAuthor := make(map[string][]string)
+
+	// Indexes from before Undetailed was kept can't tell, so all is rewritten
+	stored := make(map[string]bool)
+	if previous != nil && previous.Undetailed != nil {
+		for _, sha := range previous.SHAs {
+			stored[sha] = true
+		}
+		for _, sha := range previous.Undetailed {
+			delete(stored, sha)
+		}
+	}
+
+	index.Undetailed = []string{}
+	for _, record := range records {
+		if !stored[record.SHA] {
+			data, err := json.Marshal(record)
+			if err != nil {
+				continue
+			}
+			if appErr := p.API.KVSet(commitRecordKey(repo, record.SHA), data); appErr != nil {
+				p.API.LogWarn("Failed to save commit", "repo", repo, "sha", record.SHA, "error", appErr.Error())
+				continue
+			}
+			if !record.HasDetails {
+				index.Undetailed = append(index.Undetailed, record.SHA)
+			}
+		}
+		index.SHAs = append(index.SHAs, record.SHA)
+		if record.Author != "" {
+			byAuthor[record.Author] = append(byAuthor[record.Author], record.SHA)
+		}
+	}
+
+	index.Authors = make([]string, 0, len(byAuthor))
+	for login := range byAuthor {
+		index.Authors = append(index.Authors, login)
+	}
+	sort.Strings(index.Authors)
+
+	if data, err := json.Marshal(index); err == nil {
+		key := repoWeekKey(commitWeekIndexPrefix, repo, week)
+		if appErr := p.API.KVSet(key, data); appErr != nil {
+			p.API.LogWarn("Failed to save commit index", "repo", repo, "week", week, "error", appErr.Error())
+		} else if err := indexRepoKey(p.API, repo, key); err != nil {
+			p.API.LogWarn("Failed to index commit index key", "repo", repo, "week", week, "error", err.Error())
+		}
+	}
+
+	for login, shas := range byAuthor {
+		p.setAuthorIndexEntry(login, week, repo, shas)
+	}
+
+	if previous != nil {
+		saved := make(map[string]bool, len(index.SHAs))
+		for _, sha := range index.SHAs {
+			saved[sha] = true
+		}
+		for _, sha := range previous.SHAs {
+			if !saved[sha] {
+				p.API.KVDelete(commitRecordKey(repo, sha))
+			}
+		}
+		for _, login := range p.indexAuthors(repo, previous) {
+			if _, ok := byAuthor[login]; !ok {
+				p.removeFromAuthorIndex(login, week, repo)
+			}
+		}
+	}
+}
+
+// setAuthorIndexEntry replaces one repo's entry in an author's weekly index
+func (p *Plugin) setAuthorIndexEntry(login, week, repo string, shas []string) {
+	p.updateAuthorIndex(login, week, func(index map[string][]string) bool {
+		if slices.Equal(index[repo], shas) {
+			return false
+		}
+		index[repo] = shas
+		return true
+	})
+}
+
+// removeFromAuthorIndex drops a repo's entry from an author's weekly index
+func (p *Plugin) removeFromAuthorIndex(login, week, repo string) {
+	p.updateAuthorIndex(login, week, func(index map[string][]string) bool {
+		if _, ok := index[repo]; !ok {
+			return false
+		}
+		delete(index, repo)
+		return true
+	})
+}
+
+// updateAuthorIndex applies fn to an author's weekly index (repo -> shas),
+// saving it if fn returns true and deleting it once no repo is left. Fetches
+// of different repos share the index, so it is updated with compare-and-set.
+func (p *Plugin) updateAuthorIndex(login, week string, fn func(index map[string][]string) bool) {
+	key := authorIndexKey(login, week)
+	for i := 0; i < maxKeyIndexRetries; i++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			p.API.LogWarn("Failed to load author index", "login", login, "week", week, "error", appErr.Error())
+			return
+		}
+		index := make(map[string][]string)
+		if old != nil && json.Unmarshal(old, &index) != nil {
+			return
+		}
+		if !fn(index) {
+			return
+		}
+
+		var ok bool
+		if len(index) == 0 {
+			ok, appErr = p.API.KVSetWithOptions(key, nil, model.PluginKVSetOptions{Atomic: true, OldValue: old})
+		} else {
+			data, err := json.Marshal(index)
+			if err != nil {
+				return
+			}
+			ok, appErr = p.API.KVCompareAndSet(key, old, data)
+		}
+		if appErr != nil {
+			p.API.LogWarn("Failed to save author index", "login", login, "week", week, "error", appErr.Error())
+			return
+		}
+		if ok {
+			return
+		}
+	}
+	p.API.LogWarn("Failed to save author index", "login", login, "week", week, "error", "changed concurrently")
+}
+
+// indexAuthors returns the authors of a repo-week's stored commits, reading
+// the records for indexes that don't list them
+func (p *Plugin) indexAuthors(repo string, index *CommitWeekIndex) []string {
+	if index.Authors != nil {
+		return index.Authors
+	}
+	seen := make(map[string]bool)
+	var authors []string
+	for _, record := range p.loadCommitRecords(repo, index.SHAs) {
+		if record.Author != "" && !seen[record.Author] {
+			seen[record.Author] = true
+			authors = append(authors, record.Author)
+		}
+	}
+	return authors
+}
+
+// loadCommitWeekIndex returns the stored commit index of a repo+week, or nil
+func (p *Plugin) loadCommitWeekIndex(repo, week string) *CommitWeekIndex {
+	data, appErr := p.API.KVGet(repoWeekKey(commitWeekIndexPrefix, repo, week))
+	if appErr != nil || data == nil {
+		return nil
+	}
+	var index CommitWeekIndex
+	if json.Unmarshal(data, &index) != nil {
+		return nil
+	}
+	return &index
+}
+
+// purgeCommitRecords deletes the stored commits and index of a repo+week, and
+// the repo's entries in the week's author indexes
+func (p *Plugin) purgeCommitRecords(repo, week string) {
+	index := p.loadCommitWeekIndex(repo, week)
+	if index == nil {
+		return
+	}
+	for _, login := range p.indexAuthors(repo, index) {
+		p.removeFromAuthorIndex(login, week, repo)
+	}
+	for _, sha := range index.SHAs {
+		p.API.KVDelete(commitRecordKey(repo, sha))
+	}
+	if appErr := p.API.KVDelete(repoWeekKey(commitWeekIndexPrefix, repo, week)); appErr != nil {
+		p.API.LogWarn("Failed to purge commit index", "repo", repo, "week", week, "error", appErr.Error())
+	}
+}
+
+// loadCommitWeekRecords loads the stored records of a repo+week
+func (p *Plugin) loadCommitWeekRecords(repo, week string) []CommitRecord {
+	index := p.loadCommitWeekIndex(repo, week)
+	if index == nil {
+		return nil
+	}
+	return p.loadCommitRecords(repo, index.SHAs)
+}
+
+// loadCommitRecords loads stored records by repo and sha, skipping missing ones
+func (p *Plugin) loadCommitRecords(repo string, shas []string) []CommitRecord {
+	records := make([]CommitRecord, 0, len(shas))
+	for _, sha := range shas {
+		data, appErr := p.API.KVGet(commitRecordKey(repo, sha))
+		if appErr != nil || data == nil {
+			continue
+		}
+		var record CommitRecord
+		if json.Unmarshal(data, &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// forEachRecentCommit calls fn with the stored commits of every tracked repo
+// over the current and the given number of past weeks. Authors without a
+// GitHub account are set to their email author.
+func (p *Plugin) forEachRecentCommit(config *configuration, weeks int, fn func(record CommitRecord)) {
+	current := currentISOWeek()
+	for _, repo := range trackedRepos(config) {
+		for _, week := range append(lastWeeks(current, weeks), current) {
+			for _, record := range p.loadCommitWeekRecords(repo, week) {
+				if record.Author == "" {
+					record.Author = emailAuthor(record.AuthorEmail)
+				}
+				fn(record)
+			}
+		}
+	}
+}
+
+// loadAuthorCommitRecords loads an author's stored records for a week across repos
+func (p *Plugin) loadAuthorCommitRecords(login, week string) []CommitRecord {
+	data, appErr := p.API.KVGet(authorIndexKey(login, week))
+	if appErr != nil || data == nil {
+		return nil
+	}
+	index := make(map[string][]string)
+	if json.Unmarshal(data, &index) != nil {
+		return nil
+	}
+
+	var records []CommitRecord
+	for repo, shas := range index {
+		records = append(records, p.loadCommitRecords(repo, shas)...)
+	}
+	return records
+}
+
+// rebuildWeeklyStats recomputes a repo+week aggregate from stored commit records
+// without calling GitHub. It returns nil if the week's commits aren't stored.
+func (p *Plugin) rebuildWeeklyStats(repo, week string) *WeeklyRepoStats {
+	index := p.loadCommitWeekIndex(repo, week)
+	if index == nil {
+		return nil
+	}
+	records := p.loadCommitRecords(repo, index.SHAs)
+	if len(records) != len(index.SHAs) {
+		return nil
+	}
+
+	return &WeeklyRepoStats{
+		SchemaVersion:  weeklyStatsSchemaVersion,
+		Week:           week,
+		Repo:           repo,
+		Users:          aggregateRecords(records),
+		FetchedAt:      index.FetchedAt,
+		Status:         index.Status,
+		MissingDetails: index.MissingDetails,
+		FailedDetails:  index.FailedDetails,
+		PageLimit:      index.PageLimit,
+		PageFailed:     index.PageFailed,
+	}
+}
+
+// CommitGroup sums commits sharing a group_by key
+type CommitGroup struct {
+	Key     string `json:"key"`
+	Commits int    `json:"commits"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// CommitQueryResponse is the result of an ad-hoc commit query
+type CommitQueryResponse struct {
+	Total     int            `json:"total"`
+	Commits   []CommitRecord `json:"commits"`
+	Groups    []CommitGroup  `json:"groups,omitempty"`
+	WeekStart string         `json:"week_start"`
+	WeekEnd   string         `json:"week_end"`
+}
+
+// handleQueryCommits answers ad-hoc questions from the local commit store without
+// calling GitHub (admin only). Filters: repo, author, path (prefix), message (substring).
+// group_by: repo, author, path, hour or weekday.
+func (p *Plugin) handleQueryCommits(w http.ResponseWriter, r *http.Request) {
+	// Records carry author emails and full messages
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	config := p.getConfiguration()
+	query := r.URL.Query()
+	weekStart, weekEnd := weekRangeFromQuery(r)
+
+	repos := trackedRepos(config)
+	if repo := query.Get("repo"); repo != "" {
+		tracked, ok := findTrackedRepo(config, repo)
+		if !ok {
+			http.Error(w, `{"error": "repository is not tracked"}`, http.StatusNotFound)
+			return
+		}
+		repos = []string{tracked}
+	}
+
+	groupBy := query.Get("group_by")
+	switch groupBy {
+	case "", "repo", "author", "path", "hour", "weekday":
+	default:
+		http.Error(w, `{"error": "group_by must be one of repo, author, path, hour, weekday"}`, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultCommitQueryLimit
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, maxCommitQueryLimit)
+	}
+
+	author := query.Get("author")
+	weeks := p.getWeeksInRange(weekStart, weekEnd)
+
+	var records []CommitRecord
+	for _, week := range weeks {
+		if author != "" {
+			for _, record := range p.loadAuthorCommitRecords(author, week) {
+				if containsRepo(repos, record.Repo) {
+					records = append(records, record)
+				}
+			}
+			continue
+		}
+		for _, repo := range repos {
+			if index := p.loadCommitWeekIndex(repo, week); index != nil {
+				records = append(records, p.loadCommitRecords(repo, index.SHAs)...)
+			}
+		}
+	}
+
+	filter := commitFilter{
+		path:    query.Get("path"),
+		message: strings.ToLower(query.Get("message")),
+	}
+	matched := make([]CommitRecord, 0)
+	for _, record := range records {
+		if filter.matches(record) {
+			matched = append(matched, record)
+		}
+	}
+
+	// Newest first
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].AuthoredAt > matched[j].AuthoredAt
+	})
+
+	response := CommitQueryResponse{
+		Total:     len(matched),
+		Commits:   matched,
+		WeekStart: weekStart,
+		WeekEnd:   weekEnd,
+	}
+	if groupBy != "" {
+		response.Groups = groupCommits(matched, groupBy, filter.path)
+	}
+	if len(response.Commits) > limit {
+		response.Commits = response.Commits[:limit]
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+type commitFilter struct {
+	path    string // path prefix
+	message string // lowercased substring
+}
+
+func (f commitFilter) matches(record CommitRecord) bool {
+	if f.message != "" && !strings.Contains(strings.ToLower(record.Message), f.message) {
+		return false
+	}
+	if f.path == "" {
+		return true
+	}
+	for _, file := range record.Files {
+		if strings.HasPrefix(file.Path, f.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupCommits sums records by key. Grouping by path counts each touched file
+// (under pathPrefix, if set) with that file's line counts.
+func groupCommits(records []CommitRecord, groupBy, pathPrefix string) []CommitGroup {
+	groups := make(map[string]*CommitGroup)
+	add := func(key string, added, removed int) {
+		g := groups[key]
+		if g == nil {
+			g = &CommitGroup{Key: key}
+			groups[key] = g
+		}
+		g.Commits++
+		g.Added += added
+		g.Removed += removed
+	}
+
+	for _, record := range records {
+		switch groupBy {
+		case "repo":
+			add(record.Repo, record.Added, record.Removed)
+		case "author":
+			key := record.Author
+			if key == "" {
+				key = record.AuthorEmail
+			}
+			add(key, record.Added, record.Removed)
+		case "path":
+			for _, file := range record.Files {
+				if strings.HasPrefix(file.Path, pathPrefix) {
+					add(file.Path, file.Added, file.Removed)
+				}
+			}
+		case "hour", "weekday":
+			at, err := time.Parse(time.RFC3339, record.AuthoredAt)
+			if err != nil {
+				continue
+			}
+			key := strconv.Itoa(at.UTC().Hour())
+			if groupBy == "weekday" {
+				key = at.UTC().Weekday().String()
+			}
+			add(key, record.Added, record.Removed)
+		}
+	}
+
+	result := make([]CommitGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Commits != result[j].Commits {
+			return result[i].Commits > result[j].Commits
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func containsRepo(repos []string, repo string) bool {
+	for _, r := range repos {
+		if strings.EqualFold(r, repo) {
+			return true
+		}
+	}
+	return false
+}
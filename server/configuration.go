@@ -1,9 +1,22 @@
 package main
 
+import "strings"
+
 type configuration struct {
-	GitHubToken  string `json:"github_token"`
-	Repositories string `json:"repositories"`
-	UserMappings string `json:"user_mappings"`
+	GitHubToken         string `json:"github_token"`
+	Repositories        string `json:"repositories"`
+	UserMappings        string `json:"user_mappings"`
+	TeamGroups          string `json:"team_groups"`
+	ChurnWindowDays     int    `json:"churn_window_days"`
+	SyncEnabled         bool   `json:"sync_enabled"`
+	SyncWeeks           int    `json:"sync_weeks"`
+	SyncIntervalMinutes int    `json:"sync_interval_minutes"`
+	LocalRepoPaths      string `json:"local_repo_paths"`
+	OAuthClientID       string `json:"github_oauth_client_id"`
+	OAuthBaseURL        string `json:"github_oauth_base_url"`
+	OAuthAPIURL         string `json:"github_oauth_api_url"`
+	UnmappedReportDays  int    `json:"unmapped_report_days"`
+	ProfileAttribute    string `json:"github_profile_attribute"`
 }
 
 func (c *configuration) Clone() *configuration {
@@ -35,6 +48,25 @@ func (p *Plugin) OnConfigurationChange() error {
 		return err
 	}
 
+	p.configurationLock.RLock()
+	loaded := p.configuration != nil
+	p.configurationLock.RUnlock()
+	attributeChanged := loaded && p.getConfiguration().ProfileAttribute != configuration.ProfileAttribute
+
 	p.setConfiguration(configuration)
+
+	if missing := reposWithoutSource(configuration); hasStatsSource(configuration) && len(missing) > 0 {
+		p.API.LogWarn("No GitHub token configured, repositories without a local clone are not fetched", "repos", strings.Join(missing, ", "))
+	}
+
+	// Runs before OnActivate too, so this also starts the jobs on activation
+	p.setupSyncJob()
+	p.setupReportJob()
+	p.setupProfileSyncJob()
+
+	// Remap right away when the attribute changes; clearing it removes its mappings
+	if attributeChanged {
+		go p.syncProfileMappings()
+	}
 	return nil
 }
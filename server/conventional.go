@@ -0,0 +1,64 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// commitTypeOther counts commits that don't follow Conventional Commits
+const commitTypeOther = "other"
+
+// conventionalSubject matches "type(scope)!: description"
+var conventionalSubject = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^()]*)\))?(!)?:\s+\S`)
+
+// ConventionalCommit is the parsed header of a Conventional Commits message
+type ConventionalCommit struct {
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Breaking bool   `json:"breaking,omitempty"`
+}
+
+// parseConventionalCommit parses a full commit message. Commits that don't follow
+// the convention get type "other". A "!" after the type/scope or a
+// BREAKING CHANGE footer marks the commit as breaking.
+func parseConventionalCommit(message string) ConventionalCommit {
+	subject, body := message, ""
+	if idx := strings.Index(message, "\n"); idx >= 0 {
+		subject, body = message[:idx], message[idx+1:]
+	}
+
+	m := conventionalSubject.FindStringSubmatch(strings.TrimSpace(subject))
+	if m == nil {
+		return ConventionalCommit{Type: commitTypeOther}
+	}
+
+	return ConventionalCommit{
+		Type:     strings.ToLower(m[1]),
+		Scope:    strings.TrimSpace(m[2]),
+		Breaking: m[3] == "!" || hasBreakingFooter(body),
+	}
+}
+
+// hasBreakingFooter reports whether a commit body carries a BREAKING CHANGE footer
+func hasBreakingFooter(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+			return true
+		}
+	}
+	return false
+}
+
+// addTypeCounts merges commit type counts from src into dst, allocating dst if needed
+func addTypeCounts(dst, src map[string]int) map[string]int {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]int)
+	}
+	for typ, n := range src {
+		dst[typ] += n
+	}
+	return dst
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConventionalCommit(t *testing.T) {
+	tests := []struct {
+		message string
+		want    ConventionalCommit
+	}{
+		{"feat: add release report", ConventionalCommit{Type: "feat"}},
+		{"Fix: handle empty weeks", ConventionalCommit{Type: "fix"}},
+		{"feat(api): add release report", ConventionalCommit{Type: "feat", Scope: "api"}},
+		{"fix( webapp ): trim scope", ConventionalCommit{Type: "fix", Scope: "webapp"}},
+		{"feat!: drop v1 endpoints", ConventionalCommit{Type: "feat", Breaking: true}},
+		{"refactor(store)!: new key layout", ConventionalCommit{Type: "refactor", Scope: "store", Breaking: true}},
+		{"feat: new key layout\n\nBREAKING CHANGE: caches are rebuilt", ConventionalCommit{Type: "feat", Breaking: true}},
+		{"feat: new key layout\n\nBREAKING-CHANGE: caches are rebuilt", ConventionalCommit{Type: "feat", Breaking: true}},
+		{"fix: typo\n\nNo BREAKING CHANGE: here", ConventionalCommit{Type: "fix"}},
+		{"Merge pull request #12 from owner/branch", ConventionalCommit{Type: commitTypeOther}},
+		{"Update README.md", ConventionalCommit{Type: commitTypeOther}},
+		{"feat:missing space", ConventionalCommit{Type: commitTypeOther}},
+		{"feat(api: unclosed scope", ConventionalCommit{Type: commitTypeOther}},
+		{"fix!:", ConventionalCommit{Type: commitTypeOther}},
+		{"Revert \"feat: add release report\"\n\nBREAKING CHANGE: no", ConventionalCommit{Type: commitTypeOther}},
+		{"", ConventionalCommit{Type: commitTypeOther}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseConventionalCommit(tt.message), tt.message)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// fetchLockTimeout bounds how long a caller waits for another node's fetch of the
+// same repo-week; a full GitHub fetch with commit details can take a while
+const fetchLockTimeout = 2 * time.Minute
+
+// refreshWeeklyStats fetches and stores a repo+week. Concurrent callers on this
+// node share one fetch, and a cluster mutex per repo-week keeps other nodes from
+// fetching it at the same time.
+func (p *Plugin) refreshWeeklyStats(repo, week string, isCurrentWeek bool, token string) *WeeklyRepoStats {
+	return p.refreshWeeklyStatsSince(repo, week, isCurrentWeek, token, time.Now())
+}
+
+// refreshWeeklyStatsSince is refreshWeeklyStats for a caller that wants data no
+// older than requestedAt: if the week was fetched successfully since then, by
+// this node or another, the stored result is returned instead of fetching again.
+// Repos that can't be fetched return nil.
+func (p *Plugin) refreshWeeklyStatsSince(repo, week string, isCurrentWeek bool, token string, requestedAt time.Time) *WeeklyRepoStats {
+	if !repoHasSource(p.getConfiguration(), repo) {
+		return nil
+	}
+	key := repoWeekKey("gh_fetch_lock", repo, week)
+
+	result, _, _ := p.fetchGroup.Do(key, func() (interface{}, error) {
+		return p.refreshWeeklyStatsLocked(key, repo, week, isCurrentWeek, token, requestedAt), nil
+	})
+	stats, _ := result.(*WeeklyRepoStats)
+	return stats
+}
+
+func (p *Plugin) refreshWeeklyStatsLocked(key, repo, week string, isCurrentWeek bool, token string, requestedAt time.Time) *WeeklyRepoStats {
+	mutex, err := cluster.NewMutex(p.API, key)
+	if err != nil {
+		p.API.LogWarn("Failed to create fetch lock", "repo", repo, "week", week, "error", err.Error())
+		return p.fetchAndStoreWeek(repo, week, isCurrentWeek, token)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchLockTimeout)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		p.API.LogWarn("Timed out waiting for fetch lock", "repo", repo, "week", week)
+		return p.storedWeeklyStats(repo, week, isCurrentWeek)
+	}
+	defer mutex.Unlock()
+
+	// Another node may have fetched the week while this one waited
+	if stats := p.storedWeeklyStats(repo, week, isCurrentWeek); stats != nil && fetchedSince(stats, requestedAt) {
+		return stats
+	}
+	return p.fetchAndStoreWeek(repo, week, isCurrentWeek, token)
+}
+
+// fetchedSince reports whether stats were fetched at or after t. FetchedAt has
+// whole seconds, so a fetch in the same second as t counts as older.
+func fetchedSince(stats *WeeklyRepoStats, t time.Time) bool {
+	fetchedAt, err := time.Parse(time.RFC3339, stats.FetchedAt)
+	return err == nil && !fetchedAt.Before(t)
+}
+
+// storedWeeklyStats returns what the last fetch of a repo+week stored, or nil
+func (p *Plugin) storedWeeklyStats(repo, week string, isCurrentWeek bool) *WeeklyRepoStats {
+	if isCurrentWeek {
+		return p.loadCurrentWeekSnapshot(repo, week)
+	}
+	return p.loadCachedWeeklyStats(repo, week)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// newGitHubRequest builds an authenticated GET request for the GitHub REST API
+func newGitHubRequest(url, token string) *http.Request {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	return req
+}
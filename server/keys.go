@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// KV keys are built as prefix:hash:suffix. Hashing the repo keeps names such as
+// acme_web/app and acme/web_app apart and keeps keys well under the KV key limit.
+// Keys of the first scheme (prefix_owner_repo_suffix) never contain a colon.
+const (
+	keySchemeVersion      = 2
+	keySchemeKey          = "kv_key_scheme"
+	keySchemeMigrationKey = "kv_key_scheme_migration"
+	repoKeyIndexPrefix    = "gh_keyidx"
+	maxKeyIndexRetries    = 5
+)
+
+// keyHash returns a short stable hash of a repo name or login. GitHub names are
+// case-insensitive, so they are hashed lowercased.
+func keyHash(name string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// repoWeekKey builds the KV key for per repo+week data (or per repo+sha)
+func repoWeekKey(prefix, repo, week string) string {
+	return fmt.Sprintf("%s:%s:%s", prefix, keyHash(repo), week)
+}
+
+// repoKeyIndexKey is the key of the list of weeks with keys stored for a repo
+func repoKeyIndexKey(repo string) string {
+	return repoKeyIndexPrefix + ":" + keyHash(repo)
+}
+
+// RepoKeyIndex lists what is stored for a repo so it can be purged without
+// knowing which weeks exist. The repo's index lists the weeks, and each week's
+// index, at repoWeekKey(repoKeyIndexPrefix, repo, week), lists the keys of that
+// week. Commit records are reached through the commit week indexes listed
+// there. Indexes of older builds list all keys of the repo in Keys.
+type RepoKeyIndex struct {
+	Repo  string   `json:"repo"`
+	Keys  []string `json:"keys,omitempty"`
+	Weeks []string `json:"weeks,omitempty"`
+}
+
+// keyWeek returns the week a repo-week key ends with
+func keyWeek(key string) string {
+	return key[strings.LastIndex(key, ":")+1:]
+}
+
+// indexRepoKey adds a repo-week key to its week's index, and a new week to the
+// repo's index. Keys are rewritten on every fetch, so a key that is already
+// indexed costs one read of the small week index.
+func indexRepoKey(api plugin.API, repo, key string) error {
+	week := keyWeek(key)
+	created := false
+	err := updateKeyIndex(api, repoWeekKey(repoKeyIndexPrefix, repo, week), repo, func(index *RepoKeyIndex, existed bool) bool {
+		created = !existed
+		return addIndexEntry(&index.Keys, key)
+	})
+	if err != nil || !created {
+		return err
+	}
+	return updateKeyIndex(api, repoKeyIndexKey(repo), repo, func(index *RepoKeyIndex, _ bool) bool {
+		return addIndexEntry(&index.Weeks, week)
+	})
+}
+
+// unindexRepoKeys removes purged keys of one week from the week's index
+func unindexRepoKeys(api plugin.API, repo, week string, keys ...string) error {
+	return updateKeyIndex(api, repoWeekKey(repoKeyIndexPrefix, repo, week), repo, func(index *RepoKeyIndex, existed bool) bool {
+		if !existed {
+			return false
+		}
+		remaining := index.Keys[:0]
+		for _, k := range index.Keys {
+			if !slices.Contains(keys, k) {
+				remaining = append(remaining, k)
+			}
+		}
+		changed := len(remaining) != len(index.Keys)
+		index.Keys = remaining
+		return changed
+	})
+}
+
+// addIndexEntry appends value unless listed, reporting whether it was added
+func addIndexEntry(list *[]string, value string) bool {
+	if slices.Contains(*list, value) {
+		return false
+	}
+	*list = append(*list, value)
+	return true
+}
+
+// updateKeyIndex applies fn to a key index, saving it if fn returns true.
+// Nodes may write concurrently, so the index is updated with compare-and-set.
+func updateKeyIndex(api plugin.API, indexKey, repo string, fn func(index *RepoKeyIndex, existed bool) bool) error {
+	for i := 0; i < maxKeyIndexRetries; i++ {
+		old, appErr := api.KVGet(indexKey)
+		if appErr != nil {
+			return appErr
+		}
+
+		index := RepoKeyIndex{Repo: repo}
+		if old != nil {
+			if err := json.Unmarshal(old, &index); err != nil {
+				return err
+			}
+		}
+		if !fn(&index, old != nil) {
+			return nil
+		}
+
+		data, err := json.Marshal(index)
+		if err != nil {
+			return err
+		}
+		ok, appErr := api.KVCompareAndSet(indexKey, old, data)
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("key index of %s changed concurrently", repo)
+}
+
+// purgeRepo deletes every stored key of a repo, including its commit records
+// and author index entries, and returns how many keys were deleted. The repo
+// doesn't need to be tracked.
+func (p *Plugin) purgeRepo(repo string) (int, error) {
+	data, appErr := p.API.KVGet(repoKeyIndexKey(repo))
+	if appErr != nil {
+		return 0, appErr
+	}
+	if data == nil {
+		return 0, nil
+	}
+	var index RepoKeyIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return 0, err
+	}
+
+	keys := index.Keys
+	for _, week := range index.Weeks {
+		weekIndexKey := repoWeekKey(repoKeyIndexPrefix, repo, week)
+		var weekIndex RepoKeyIndex
+		if raw, appErr := p.API.KVGet(weekIndexKey); appErr == nil && raw != nil && json.Unmarshal(raw, &weekIndex) == nil {
+			keys = append(keys, weekIndex.Keys...)
+		}
+		keys = append(keys, weekIndexKey)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, commitWeekIndexPrefix+":") {
+			var weekIndex CommitWeekIndex
+			if raw, appErr := p.API.KVGet(key); appErr == nil && raw != nil && json.Unmarshal(raw, &weekIndex) == nil {
+				for _, login := range p.indexAuthors(repo, &weekIndex) {
+					p.removeFromAuthorIndex(login, weekIndex.Week, repo)
+				}
+				for _, sha := range weekIndex.SHAs {
+					if p.API.KVDelete(commitRecordKey(repo, sha)) == nil {
+						deleted++
+					}
+				}
+			}
+		}
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			p.API.LogWarn("Failed to purge key", "repo", repo, "key", key, "error", appErr.Error())
+			continue
+		}
+		deleted++
+	}
+
+	if appErr := p.API.KVDelete(repoKeyIndexKey(repo)); appErr != nil {
+		return deleted, appErr
+	}
+	return deleted, nil
+}
+
+// migrateKeyScheme moves entries written under the first key scheme to the
+// current one. It runs once per installation, on one node at a time.
+func (p *Plugin) migrateKeyScheme() {
+	mutex, err := cluster.NewMutex(p.API, keySchemeMigrationKey)
+	if err != nil {
+		p.API.LogError("Failed to create key migration lock", "error", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		return // another node is migrating
+	}
+	defer mutex.Unlock()
+
+	if data, appErr := p.API.KVGet(keySchemeKey); appErr == nil && string(data) == fmt.Sprint(keySchemeVersion) {
+		return
+	}
+
+	// Collect first: deleting while paging would shift later pages
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			p.API.LogError("Failed to list keys for migration", "error", appErr.Error())
+			return
+		}
+		keys = append(keys, pageKeys...)
+		if len(pageKeys) < kvListPageSize {
+			break
+		}
+	}
+
+	migrated, failed := 0, 0
+	for _, key := range keys {
+		if strings.Contains(key, ":") {
+			continue
+		}
+		ok, handled := p.migrateKey(key)
+		if !handled {
+			continue
+		}
+		if ok {
+			migrated++
+		} else {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		// Leave the version unset so the next activation retries
+		p.API.LogWarn("KV key migration incomplete", "migrated", migrated, "failed", failed)
+		return
+	}
+	if appErr := p.API.KVSet(keySchemeKey, []byte(fmt.Sprint(keySchemeVersion))); appErr != nil {
+		p.API.LogError("Failed to save key scheme version", "error", appErr.Error())
+		return
+	}
+	p.API.LogInfo("Migrated KV keys", "count", migrated, "key_scheme", keySchemeVersion)
+}
+
+// migrateKey moves one old-scheme entry. handled is false for keys that aren't
+// plugin data of the old scheme.
+func (p *Plugin) migrateKey(key string) (ok bool, handled bool) {
+	prefix := ""
+	for _, candidate := range []string{"gh_stats", "gh_current", "gh_patches", commitWeekIndexPrefix, "gh_cidx_author", fetchMetaKeyPrefix, "gh_commit"} {
+		if strings.HasPrefix(key, candidate+"_") {
+			prefix = candidate
+			break
+		}
+	}
+	if prefix == "" {
+		return false, false
+	}
+
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return false, true
+	}
+	if data == nil {
+		return true, true // expired meanwhile
+	}
+
+	// The old key can't be parsed reliably, so the new one is built from the value
+	var value struct {
+		Repo   string `json:"repo"`
+		Week   string `json:"week"`
+		SHA    string `json:"sha"`
+		Status string `json:"status"`
+	}
+	var newKey, repo string
+	var ttl time.Duration
+	switch prefix {
+	case "gh_cidx_author":
+		// gh_cidx_author_<login>_<week>; weeks have a fixed length
+		rest := strings.TrimPrefix(key, prefix+"_")
+		if len(rest) < len("_2006-W01")+1 {
+			return p.API.KVDelete(key) == nil, true
+		}
+		login, week := rest[:len(rest)-len("_2006-W01")], rest[len(rest)-len("2006-W01"):]
+		newKey = authorIndexKey(login, week)
+	default:
+		// Undecodable entries are cache data that can be refetched
+		if json.Unmarshal(data, &value) != nil || value.Repo == "" {
+			return p.API.KVDelete(key) == nil, true
+		}
+		repo = value.Repo
+		suffix := value.Week
+		if prefix == "gh_commit" {
+			suffix = value.SHA
+		}
+		if suffix == "" {
+			return p.API.KVDelete(key) == nil, true
+		}
+		newKey = repoWeekKey(prefix, repo, suffix)
+		switch prefix {
+		case "gh_stats":
+			ttl = weekStatsTTL(value.Status)
+		case "gh_current":
+			ttl = currentWeekTTL
+		}
+	}
+
+	// Don't overwrite data written under the new key since activation
+	if _, appErr := p.API.KVSetWithOptions(newKey, data, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(ttl / time.Second),
+	}); appErr != nil {
+		p.API.LogWarn("Failed to migrate key", "key", key, "error", appErr.Error())
+		return false, true
+	}
+	if repo != "" && prefix != "gh_commit" {
+		if err := indexRepoKey(p.API, repo, newKey); err != nil {
+			p.API.LogWarn("Failed to index migrated key", "key", newKey, "error", err.Error())
+		}
+	}
+	if appErr := p.API.KVDelete(key); appErr != nil {
+		return false, true
+	}
+	return true, true
+}
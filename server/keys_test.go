@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV backs the KV methods of a plugintest API with a map
+type fakeKV struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func newFakeKV(api *plugintest.API) *fakeKV {
+	kv := &fakeKV{data: make(map[string][]byte)}
+	api.On("KVGet", mock.Anything).Return(func(key string) ([]byte, *model.AppError) {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		return kv.data[key], nil
+	}).Maybe()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		kv.set(key, value)
+		return nil
+	}).Maybe()
+	api.On("KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, _ int64) *model.AppError {
+		kv.set(key, value)
+		return nil
+	}).Maybe()
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		kv.set(key, nil)
+		return nil
+	}).Maybe()
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, old, value []byte) (bool, *model.AppError) {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if !bytes.Equal(kv.data[key], old) {
+			return false, nil
+		}
+		kv.data[key] = value
+		return true, nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if options.Atomic && !bytes.Equal(kv.data[key], options.OldValue) {
+			return false, nil
+		}
+		if value == nil {
+			delete(kv.data, key)
+		} else {
+			kv.data[key] = value
+		}
+		return true, nil
+	}).Maybe()
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) ([]string, *model.AppError) {
+		keys := kv.keys()
+		start := min(page*perPage, len(keys))
+		return keys[start:min(start+perPage, len(keys))], nil
+	}).Maybe()
+	return kv
+}
+
+func (kv *fakeKV) set(key string, value []byte) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	if value == nil {
+		delete(kv.data, key)
+		return
+	}
+	kv.data[key] = value
+}
+
+func (kv *fakeKV) keys() []string {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	keys := make([]string, 0, len(kv.data))
+	for key := range kv.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (kv *fakeKV) setJSON(t *testing.T, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	kv.set(key, data)
+}
+
+func TestRepoWeekKey(t *testing.T) {
+	assert.NotEqual(t, repoWeekKey("gh_stats", "acme_web/app", "2025-W10"), repoWeekKey("gh_stats", "acme/web_app", "2025-W10"))
+	assert.Equal(t, repoWeekKey("gh_stats", "Acme/App", "2025-W10"), repoWeekKey("gh_stats", "acme/app", "2025-W10"))
+
+	long := strings.Repeat("a", 100) + "/" + strings.Repeat("b", 100)
+	assert.LessOrEqual(t, len("mutex_"+repoWeekKey("gh_fetch_lock", long, "2025-W10")), 150)
+	assert.LessOrEqual(t, len(authorIndexKey(strings.Repeat("c", 200)+"@example.com", "2025-W10")), 150)
+}
+
+func TestMigrateKeyScheme(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	kv := newFakeKV(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// Old keys of two repos that collided under the first scheme
+	kv.setJSON(t, "gh_stats_acme_web_app_2025-W10", WeeklyRepoStats{SchemaVersion: weeklyStatsSchemaVersion, Repo: "acme/web_app", Week: "2025-W10", Status: weekStatusComplete})
+	kv.setJSON(t, "gh_current_acme_app_2025-W11", WeeklyRepoStats{Repo: "acme/app", Week: "2025-W11"})
+	kv.setJSON(t, "gh_commit_acme_app_abc123", CommitRecord{SHA: "abc123", Repo: "acme/app"})
+	kv.setJSON(t, "gh_cidx_week_acme_app_2025-W10", CommitWeekIndex{Repo: "acme/app", Week: "2025-W10", SHAs: []string{"abc123"}})
+	kv.setJSON(t, "gh_cidx_author_octo_cat_2025-W10", map[string][]string{"acme/app": {"abc123"}})
+	kv.set("user_mappings", []byte(`{}`))
+
+	p.migrateKeyScheme()
+
+	assert.Equal(t, []string{
+		authorIndexKey("octo_cat", "2025-W10"),
+		repoWeekKey(commitWeekIndexPrefix, "acme/app", "2025-W10"),
+		repoWeekKey("gh_commit", "acme/app", "abc123"),
+		repoWeekKey("gh_current", "acme/app", "2025-W11"),
+		repoKeyIndexKey("acme/app"),
+		repoWeekKey(repoKeyIndexPrefix, "acme/app", "2025-W10"),
+		repoWeekKey(repoKeyIndexPrefix, "acme/app", "2025-W11"),
+		repoKeyIndexKey("acme/web_app"),
+		repoWeekKey(repoKeyIndexPrefix, "acme/web_app", "2025-W10"),
+		repoWeekKey("gh_stats", "acme/web_app", "2025-W10"),
+		keySchemeKey,
+		"user_mappings",
+	}, kv.keys())
+
+	// Everything but the commit records is indexed per repo, so a repo can be purged
+	deleted, err := p.purgeRepo("acme/app")
+	require.NoError(t, err)
+	assert.Equal(t, 5, deleted) // with the two week indexes
+	assert.NotContains(t, kv.keys(), repoWeekKey("gh_commit", "acme/app", "abc123"))
+	assert.Contains(t, kv.keys(), repoWeekKey("gh_stats", "acme/web_app", "2025-W10"))
+
+	// Runs once
+	kv.set("gh_stats_acme_other_2025-W10", []byte(`{"repo": "acme/other", "week": "2025-W10"}`))
+	p.migrateKeyScheme()
+	assert.Contains(t, kv.keys(), "gh_stats_acme_other_2025-W10")
+}
+
+func TestCommitRecordsPruneAuthorIndexes(t *testing.T) {
+	api := &plugintest.API{}
+	kv := newFakeKV(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	stats := &WeeklyRepoStats{Status: weekStatusComplete}
+	p.saveCommitRecords("acme/app", "2025-W10", []CommitRecord{
+		{SHA: "a1", Repo: "acme/app", Author: "jane"},
+		{SHA: "a2", Repo: "acme/app", Author: "bob"},
+	}, stats)
+	p.saveCommitRecords("acme/web", "2025-W10", []CommitRecord{{SHA: "b1", Repo: "acme/web", Author: "jane"}}, stats)
+
+	// A refetch without bob's commit drops his entry and the stale record
+	p.saveCommitRecords("acme/app", "2025-W10", []CommitRecord{{SHA: "a1", Repo: "acme/app", Author: "jane"}}, stats)
+	assert.Empty(t, p.loadAuthorCommitRecords("bob", "2025-W10"))
+	assert.NotContains(t, kv.keys(), commitRecordKey("acme/app", "a2"))
+	assert.Len(t, p.loadAuthorCommitRecords("jane", "2025-W10"), 2)
+
+	// Purging a week keeps the other repo's entry
+	p.purgeCommitRecords("acme/app", "2025-W10")
+	records := p.loadAuthorCommitRecords("jane", "2025-W10")
+	require.Len(t, records, 1)
+	assert.Equal(t, "b1", records[0].SHA)
+	p.purgeCommitRecords("acme/web", "2025-W10")
+	assert.NotContains(t, kv.keys(), authorIndexKey("jane", "2025-W10"))
+	assert.NotContains(t, kv.keys(), authorIndexKey("bob", "2025-W10"))
+}
+
+func TestCommitRecordsWriteOnlyChanges(t *testing.T) {
+	api := &plugintest.API{}
+	kv := newFakeKV(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	recordWrites := func() []string {
+		var keys []string
+		for _, call := range api.Calls {
+			if call.Method == "KVSet" && strings.HasPrefix(call.Arguments.String(0), "gh_commit:") {
+				keys = append(keys, call.Arguments.String(0))
+			}
+		}
+		api.Calls = nil
+		return keys
+	}
+
+	stats := &WeeklyRepoStats{Status: weekStatusTruncated}
+	p.saveCommitRecords("acme/app", "2025-W10", []CommitRecord{
+		{SHA: "a1", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 3},
+		{SHA: "a2", Repo: "acme/app", Author: "jane"},
+	}, stats)
+	assert.Len(t, recordWrites(), 2)
+
+	// A sync of the same commits only writes the one that gained line counts and the new one
+	p.saveCommitRecords("acme/app", "2025-W10", []CommitRecord{
+		{SHA: "a1", Repo: "acme/app", Author: "jane"},
+		{SHA: "a2", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 5},
+		{SHA: "a3", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 1},
+	}, stats)
+	assert.ElementsMatch(t, []string{commitRecordKey("acme/app", "a2"), commitRecordKey("acme/app", "a3")}, recordWrites())
+
+	p.saveCommitRecords("acme/app", "2025-W10", []CommitRecord{
+		{SHA: "a1", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 3},
+		{SHA: "a2", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 5},
+		{SHA: "a3", Repo: "acme/app", Author: "jane", HasDetails: true, Added: 1},
+	}, stats)
+	assert.Empty(t, recordWrites())
+
+	// The stored line counts are kept
+	added := 0
+	for _, record := range p.loadCommitWeekRecords("acme/app", "2025-W10") {
+		added += record.Added
+	}
+	assert.Equal(t, 9, added)
+	assert.Len(t, kv.keys(), 7) // 3 records, week and author index, repo key indexes
+}
+
+func TestAuthorIndexConcurrentRepos(t *testing.T) {
+	api := &plugintest.API{}
+	kv := newFakeKV(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// Fetches of different repos hold different locks but share author indexes.
+	// Each round of compare-and-set lets one writer through, so as many
+	// writers as retries always succeed.
+	var wg sync.WaitGroup
+	for i := 0; i < maxKeyIndexRetries; i++ {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			p.setAuthorIndexEntry("jane", "2025-W10", repo, []string{"sha"})
+		}(fmt.Sprintf("acme/repo-%d", i))
+	}
+	wg.Wait()
+
+	var index map[string][]string
+	require.NoError(t, json.Unmarshal(kv.data[authorIndexKey("jane", "2025-W10")], &index))
+	assert.Len(t, index, maxKeyIndexRetries)
+}
+
+func TestIndexRepoKey(t *testing.T) {
+	api := &plugintest.API{}
+	kv := newFakeKV(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	writes := func() int {
+		n := 0
+		for _, call := range api.Calls {
+			if call.Method == "KVCompareAndSet" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// An index of an older build lists every key of the repo
+	legacyKey := repoWeekKey("gh_stats", "acme/app", "2024-W01")
+	kv.set(legacyKey, []byte(`{}`))
+	kv.setJSON(t, repoKeyIndexKey("acme/app"), RepoKeyIndex{Repo: "acme/app", Keys: []string{legacyKey}})
+
+	// Indexing a key again only reads the week's index
+	statsKey := repoWeekKey("gh_stats", "acme/app", "2025-W10")
+	patchesKey := repoWeekKey("gh_patches", "acme/app", "2025-W10")
+	require.NoError(t, indexRepoKey(api, "acme/app", statsKey))
+	assert.Equal(t, 2, writes())
+	require.NoError(t, indexRepoKey(api, "acme/app", statsKey))
+	require.NoError(t, indexRepoKey(api, "acme/app", patchesKey))
+	assert.Equal(t, 3, writes())
+
+	require.NoError(t, unindexRepoKeys(api, "acme/app", "2025-W10", statsKey))
+	var weekIndex RepoKeyIndex
+	require.NoError(t, json.Unmarshal(kv.data[repoWeekKey(repoKeyIndexPrefix, "acme/app", "2025-W10")], &weekIndex))
+	assert.Equal(t, []string{patchesKey}, weekIndex.Keys)
+
+	kv.set(patchesKey, []byte(`{}`))
+	deleted, err := p.purgeRepo("acme/app")
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	assert.Empty(t, kv.keys())
+}
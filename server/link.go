@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Default endpoints of the OAuth device flow used for account linking
+const (
+	defaultOAuthBaseURL = "https://github.com"
+	defaultOAuthAPIURL  = githubAPIURL
+)
+
+// deviceGrantType is the grant type of the OAuth device flow (RFC 8628)
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// oauthBaseURL returns where device codes and tokens are requested
+func oauthBaseURL(config *configuration) string {
+	if config.OAuthBaseURL != "" {
+		return strings.TrimRight(config.OAuthBaseURL, "/")
+	}
+	return defaultOAuthBaseURL
+}
+
+// oauthAPIURL returns the API that identifies the account behind a device token
+func oauthAPIURL(config *configuration) string {
+	if config.OAuthAPIURL != "" {
+		return strings.TrimRight(config.OAuthAPIURL, "/")
+	}
+	return defaultOAuthAPIURL
+}
+
+// deviceCode is GitHub's answer to a device authorization request
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// deviceToken is a token poll response; Error is set while the user hasn't
+// authorized yet and when the flow failed
+type deviceToken struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Interval    int    `json:"interval"`
+}
+
+// postOAuthForm posts form values to an OAuth endpoint and decodes the JSON answer
+func postOAuthForm(client *http.Client, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// startLink requests a device code and polls for the user's authorization in
+// the background. The reply tells the user where to enter the code.
+func (p *Plugin) startLink(userID, channelID string) string {
+	config := p.getConfiguration()
+	if config.OAuthClientID == "" {
+		return "Account linking is not configured. Ask a system admin to set the GitHub OAuth Client ID."
+	}
+	if _, pending := p.pendingLinks.LoadOrStore(userID, true); pending {
+		return "A link is already in progress. Enter the code shown earlier, or wait for it to expire."
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var code deviceCode
+	err := postOAuthForm(client, oauthBaseURL(config)+"/login/device/code", url.Values{"client_id": {config.OAuthClientID}}, &code)
+	if err != nil || code.DeviceCode == "" {
+		p.pendingLinks.Delete(userID)
+		if err != nil {
+			p.API.LogWarn("Failed to request device code", "error", err.Error())
+		}
+		return "Could not start linking with GitHub. Try again later."
+	}
+
+	go p.completeLink(client, config, userID, channelID, code)
+
+	return fmt.Sprintf("Open %s and enter the code **%s** to link your GitHub account. The code expires in %d minutes.",
+		code.VerificationURI, code.UserCode, code.ExpiresIn/60)
+}
+
+// completeLink polls for the device token, identifies the account and stores
+// the mapping. The token is only used to read the account and then discarded.
+func (p *Plugin) completeLink(client *http.Client, config *configuration, userID, channelID string, code deviceCode) {
+	defer p.pendingLinks.Delete(userID)
+
+	token, reason := pollDeviceToken(client, config, code)
+	if token == "" {
+		p.sendLinkResult(userID, channelID, "GitHub account was not linked: "+reason+".")
+		return
+	}
+
+	var account githubProfile
+	resp, err := client.Do(newGitHubRequest(oauthAPIURL(config)+"/user", token))
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&account)
+		}
+	}
+	if err != nil || account.Login == "" {
+		if err != nil {
+			p.API.LogWarn("Failed to identify linked GitHub account", "user_id", userID, "error", err.Error())
+		}
+		p.sendLinkResult(userID, channelID, "GitHub account was not linked: the account could not be read.")
+		return
+	}
+
+	if err := p.linkAccount(userID, account.Login, account.ID); err != nil {
+		p.API.LogError("Failed to save linked GitHub account", "user_id", userID, "login", account.Login, "error", err.Error())
+		p.sendLinkResult(userID, channelID, "GitHub account was not linked: it could not be saved.")
+		return
+	}
+	p.API.LogInfo("Linked GitHub account", "user_id", userID, "login", account.Login)
+	p.sendLinkResult(userID, channelID, fmt.Sprintf("Linked GitHub account @%s.", account.Login))
+}
+
+// pollDeviceToken waits for the user to authorize the device code. It returns
+// the access token, or "" and why the flow ended.
+func pollDeviceToken(client *http.Client, config *configuration, code deviceCode) (string, string) {
+	interval := time.Duration(max(code.Interval, 1)) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	form := url.Values{
+		"client_id":   {config.OAuthClientID},
+		"device_code": {code.DeviceCode},
+		"grant_type":  {deviceGrantType},
+	}
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var token deviceToken
+		if err := postOAuthForm(client, oauthBaseURL(config)+"/login/oauth/access_token", form, &token); err != nil {
+			continue
+		}
+		switch token.Error {
+		case "":
+			if token.AccessToken != "" {
+				return token.AccessToken, ""
+			}
+		case "authorization_pending":
+		case "slow_down":
+			interval = time.Duration(max(token.Interval, int(interval/time.Second)+5)) * time.Second
+		case "access_denied":
+			return "", "authorization was denied"
+		case "expired_token":
+			return "", "the code expired"
+		default:
+			return "", token.Error
+		}
+	}
+	return "", "the code expired"
+}
+
+// linkAccount maps a proven GitHub account to the user from today on. A proven
+// link ends an admin mapping of the same login to someone else; earlier periods
+// are kept, so past commits stay with their owner at the time.
+func (p *Plugin) linkAccount(userID, login string, githubID int64) error {
+	today := mappingDay()
+	return p.updateMappingSet(userID, "link", func(set *MappingSet) error {
+		link := UserMapping{GitHubLogin: login, GitHubID: githubID, MMUserID: userID}
+		var others []UserMapping // periods of the login that are kept
+		mappings := set.Mappings[:0]
+		for _, m := range set.Mappings {
+			matches := strings.EqualFold(m.GitHubLogin, login) || (githubID != 0 && m.GitHubID == githubID)
+			if !matches || !m.validAt(time.Now()) {
+				mappings = append(mappings, m)
+				if m.Identity() == link.Identity() {
+					others = append(others, m)
+				}
+				continue
+			}
+			if m.MMUserID == userID && m.Identity() == link.Identity() {
+				link.ValidFrom, link.ValidTo = m.ValidFrom, m.ValidTo
+				continue
+			}
+			p.API.LogInfo("Linked GitHub account replaces mapping", "login", login, "previous_user_id", m.MMUserID)
+			if ended, ok := m.endBefore(today); ok {
+				mappings = append(mappings, ended)
+				if ended.Identity() == link.Identity() {
+					others = append(others, ended)
+				}
+			}
+		}
+
+		// Fit the link between the periods kept before and after today, none of
+		// which applies today anymore
+		if link.ValidFrom == "" && link.ValidTo == "" {
+			link, _ = fitPeriod(link, others, today)
+		}
+		set.Mappings = append(mappings, link)
+		return nil
+	})
+}
+
+// unlinkAccounts ends the user's current mappings, all of them if identity is
+// empty, and returns the logins and emails unlinked. Past periods are kept so
+// earlier commits stay attributed; periods that haven't started are removed.
+func (p *Plugin) unlinkAccounts(userID, identity string) ([]string, error) {
+	identity = strings.ToLower(strings.TrimPrefix(identity, "@"))
+	today := mappingDay()
+	var removed []string
+	err := p.updateMappingSet(userID, "unlink", func(set *MappingSet) error {
+		unlinked := make(map[string]bool)
+		mappings := set.Mappings[:0]
+		for _, m := range set.Mappings {
+			if m.MMUserID != userID || (identity != "" && m.Identity() != identity) || (m.ValidTo != "" && m.ValidTo < today) {
+				mappings = append(mappings, m)
+				continue
+			}
+			if !unlinked[m.Identity()] {
+				unlinked[m.Identity()] = true
+				removed = append(removed, m.Identity())
+			}
+			if ended, ok := m.endBefore(today); ok {
+				mappings = append(mappings, ended)
+			}
+		}
+		set.Mappings = mappings
+		return nil
+	})
+	return removed, err
+}
+
+// sendLinkResult tells the user how linking ended, in the channel it started in
+func (p *Plugin) sendLinkResult(userID, channelID, message string) {
+	p.API.SendEphemeralPost(userID, &model.Post{
+		ChannelId: channelID,
+		Message:   message,
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newDeviceFlowServer stands in for GitHub's device flow and user endpoints
+func newDeviceFlowServer(t *testing.T, login string, id int64) *httptest.Server {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/device/code":
+			assert.Equal(t, "client-id", r.FormValue("client_id"))
+			json.NewEncoder(w).Encode(deviceCode{DeviceCode: "dc", UserCode: "ABCD-1234", VerificationURI: "https://github.com/login/device", ExpiresIn: 60, Interval: 1})
+		case "/login/oauth/access_token":
+			assert.Equal(t, deviceGrantType, r.FormValue("grant_type"))
+			polls++
+			if polls == 1 {
+				json.NewEncoder(w).Encode(deviceToken{Error: "authorization_pending"})
+				return
+			}
+			json.NewEncoder(w).Encode(deviceToken{AccessToken: "token"})
+		case "/user":
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(githubProfile{Login: login, ID: id})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLinkAccount(t *testing.T) {
+	server := newDeviceFlowServer(t, "octocat", 583231)
+
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{OAuthClientID: "client-id", OAuthBaseURL: server.URL, OAuthAPIURL: server.URL + "/"})
+
+	userID, otherID := model.NewId(), model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{
+		Version:    mappingSetVersion,
+		Mappings:   []UserMapping{{GitHubLogin: "Octocat", MMUserID: otherID}},
+		Unresolved: []UnresolvedMapping{{Key: "octocat", Value: "someone", Reason: "not found"}},
+	}))
+
+	api.On("GetUser", userID).Return(&model.User{Id: userID, Username: "jane"}, nil)
+
+	done := make(chan string, 1)
+	api.On("SendEphemeralPost", userID, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		done <- args.Get(1).(*model.Post).Message
+	}).Once()
+	api.On("LogInfo", "Linked GitHub account replaces mapping", "login", "octocat", "previous_user_id", otherID).Return().Once()
+	api.On("LogInfo", "Linked GitHub account", "user_id", userID, "login", "octocat").Return().Once()
+
+	reply := p.startLink(userID, "channel")
+	assert.Contains(t, reply, "ABCD-1234")
+	assert.Contains(t, p.startLink(userID, "channel"), "already in progress")
+
+	select {
+	case message := <-done:
+		assert.Equal(t, "Linked GitHub account @octocat.", message)
+	case <-time.After(10 * time.Second):
+		t.Fatal("link did not complete")
+	}
+
+	// The admin mapping ends yesterday, so earlier commits stay with its user
+	today := time.Now().UTC().Format(mappingDateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(mappingDateLayout)
+	set, err := store.GetMappings()
+	require.NoError(t, err)
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "Octocat", MMUserID: otherID, ValidTo: yesterday},
+		{GitHubLogin: "octocat", GitHubID: 583231, MMUserID: userID, ValidFrom: today},
+	}, set.Mappings)
+	assert.Empty(t, set.Unresolved)
+
+	removed, err := p.unlinkAccounts(userID, "@OctoCat")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"octocat"}, removed)
+	assert.Equal(t, []UserMapping{{GitHubLogin: "Octocat", MMUserID: otherID, ValidTo: yesterday}}, p.getMappingSet().Mappings)
+
+	// All changes are in the audit log, newest first
+	entries, err := p.queryMappingAudit(auditFilter{MMUserID: otherID}, time.Now().Add(-time.Hour), time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, auditUpdate, entries[0].Action)
+	assert.Equal(t, "link", entries[0].Source)
+	assert.Equal(t, "jane", entries[0].ActorUsername)
+	assert.Empty(t, entries[0].Before.ValidTo)
+	assert.Equal(t, yesterday, entries[0].After.ValidTo)
+
+	entries, err = p.queryMappingAudit(auditFilter{Identity: "OctoCat"}, time.Now().Add(-time.Hour), time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, auditDelete, entries[0].Action)
+	assert.Equal(t, userID, entries[0].Before.MMUserID)
+	assert.Nil(t, entries[0].After)
+}
+
+func TestLinkKeepsEarlierPeriods(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	api.On("GetUser", mock.Anything).Return(&model.User{Username: "jane"}, nil)
+
+	// hubot belonged to someone else until 2024, then to nobody
+	userID, previousID := model.NewId(), model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: previousID, ValidTo: "2024-12-31"},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidTo: "2023-06-30"},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidFrom: "2023-07-01"},
+	}}))
+
+	require.NoError(t, p.linkAccount(userID, "hubot", 480938))
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: previousID, ValidTo: "2024-12-31"},
+		{GitHubLogin: "hubot", GitHubID: 480938, MMUserID: userID, ValidFrom: "2025-01-01"},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidTo: "2023-06-30"},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidFrom: "2023-07-01"},
+	}, p.getMappingSet().Mappings)
+
+	// Unlinking ends the current periods and keeps the past ones
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(mappingDateLayout)
+	removed, err := p.unlinkAccounts(userID, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hubot", "jane-old"}, removed)
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: previousID, ValidTo: "2024-12-31"},
+		{GitHubLogin: "hubot", GitHubID: 480938, MMUserID: userID, ValidFrom: "2025-01-01", ValidTo: yesterday},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidTo: "2023-06-30"},
+		{GitHubLogin: "jane-old", MMUserID: userID, ValidFrom: "2023-07-01", ValidTo: yesterday},
+	}, p.getMappingSet().Mappings)
+}
+
+func TestLinkSurvivesStaleAdminSave(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+
+	admin := &model.User{Id: model.NewId(), Username: "admin", Roles: model.SystemAdminRoleId}
+	userID, otherID := model.NewId(), model.NewId()
+	api.On("GetUser", admin.Id).Return(admin, nil)
+	api.On("GetUser", mock.Anything).Return(&model.User{Username: "jane"}, nil)
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: otherID},
+	}}))
+
+	// The admin page loads the mappings, then the user links an account
+	w := httptest.NewRecorder()
+	p.handleGetMappings(w, httptest.NewRequest(http.MethodGet, "/api/v1/mappings", nil))
+	var loaded MappingSet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loaded))
+	require.NoError(t, p.linkAccount(userID, "octocat", 583231))
+
+	// Saving the page's list, which lacks the link, must not remove it
+	loaded.Mappings = append(loaded.Mappings, UserMapping{GitHubLogin: "monalisa", MMUserID: otherID})
+	body, err := json.Marshal(loaded)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/mappings", bytes.NewReader(body))
+	r.Header.Set("Mattermost-User-Id", admin.Id)
+	w = httptest.NewRecorder()
+	p.handleSaveMappings(w, r)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: otherID},
+		{GitHubLogin: "octocat", GitHubID: 583231, MMUserID: userID},
+	}, p.getMappingSet().Mappings)
+}
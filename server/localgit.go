@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// noreplyEmailPattern matches GitHub noreply addresses (12345+login@users.noreply.github.com)
+var noreplyEmailPattern = regexp.MustCompile(`^(?:(\d+)\+)?([^@]+)@users\.noreply\.github\.com$`)
+
+// localRepoPaths parses the owner/repo -> local bare clone path mappings from config
+func localRepoPaths(config *configuration) map[string]string {
+	paths := make(map[string]string)
+	if config.LocalRepoPaths != "" {
+		json.Unmarshal([]byte(config.LocalRepoPaths), &paths)
+	}
+	return paths
+}
+
+// localRepoPath returns the local clone configured for a tracked repo, if any
+func localRepoPath(config *configuration, repo string) (string, bool) {
+	for name, path := range localRepoPaths(config) {
+		if strings.EqualFold(name, repo) && path != "" {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// hasStatsSource reports whether stats can be computed at all: either through the
+// GitHub API or from at least one local clone
+func hasStatsSource(config *configuration) bool {
+	return config.GitHubToken != "" || len(localRepoPaths(config)) > 0
+}
+
+// repoHasSource reports whether a tracked repo can be fetched: from its local
+// clone, or through the GitHub API with a token. Unauthenticated API calls time
+// out or hit the rate limit, so without a token other repos are skipped.
+func repoHasSource(config *configuration, repo string) bool {
+	if config.GitHubToken != "" {
+		return true
+	}
+	_, ok := localRepoPath(config, repo)
+	return ok
+}
+
+// reposWithoutSource returns the tracked repos that are skipped for lack of a
+// local clone and a GitHub token
+func reposWithoutSource(config *configuration) []string {
+	var repos []string
+	for _, repo := range trackedRepos(config) {
+		if !repoHasSource(config, repo) {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// noSourceWarning is the stats warning of a repo without a source
+func noSourceWarning(repo string) string {
+	return fmt.Sprintf("%s: no local clone and no GitHub token configured, not fetched", repo)
+}
+
+// emailAuthor attributes a commit that carries no GitHub account, such as every
+// local commit. GitHub noreply addresses carry the login; other commits are
+// attributed to the lowercased author email, which can be mapped like a login.
+func emailAuthor(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if m := noreplyEmailPattern.FindStringSubmatch(email); m != nil {
+		return m[2]
+	}
+	return email
+}
+
+// fetchWeekFromLocalGit computes the same stats as fetchWeekFromGitHub by walking
+// a local (bare) clone. Every commit gets line counts, so local weeks are never
+// truncated. Like the GitHub commits API, commits are selected from HEAD by
+// committer date; history is walked newest first and the walk stops at the
+// first commit older than the week.
+func (p *Plugin) fetchWeekFromLocalGit(repo, week, path string) (*WeeklyRepoStats, *WeeklyPatches) {
+	startDate := weekToDate(week)
+	endDate := startDate.AddDate(0, 0, 7)
+
+	gitRepo, err := git.PlainOpen(path)
+	if err != nil {
+		p.API.LogWarn("Failed to open local repository", "repo", repo, "path", path, "error", err.Error())
+		return nil, nil
+	}
+
+	iter, err := gitRepo.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+	if err != nil {
+		p.API.LogWarn("Failed to read local repository log", "repo", repo, "path", path, "error", err.Error())
+		return nil, nil
+	}
+	defer iter.Close()
+
+	var records []CommitRecord
+	var patchCommits []PatchCommit
+	failed := 0
+	err = iter.ForEach(func(c *object.Commit) error {
+		if c.Committer.When.Before(startDate) {
+			return storer.ErrStop
+		}
+		if !c.Committer.When.Before(endDate) {
+			return nil
+		}
+		record := CommitRecord{
+			SHA:         c.Hash.String(),
+			Repo:        repo,
+			Author:      emailAuthor(c.Author.Email),
+			AuthorName:  c.Author.Name,
+			AuthorEmail: c.Author.Email,
+			AuthoredAt:  c.Author.When.UTC().Format(time.RFC3339),
+			CommittedAt: c.Committer.When.UTC().Format(time.RFC3339),
+			Message:     c.Message,
+		}
+
+		patchCommit, err := localCommitDetail(c, &record)
+		if err != nil {
+			failed++
+		} else if len(patchCommit.Files) > 0 {
+			patchCommits = append(patchCommits, patchCommit)
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil && !errors.Is(err, io.EOF) {
+		p.API.LogWarn("Failed to walk local repository", "repo", repo, "week", week, "error", err.Error())
+		return nil, nil
+	}
+
+	stats := &WeeklyRepoStats{
+		SchemaVersion:  weeklyStatsSchemaVersion,
+		Week:           week,
+		Repo:           repo,
+		Users:          aggregateRecords(records),
+		FetchedAt:      time.Now().Format(time.RFC3339),
+		MissingDetails: failed,
+		FailedDetails:  failed,
+	}
+	switch {
+	case len(records) == 0:
+		stats.Status = weekStatusEmpty
+	case failed > 0:
+		stats.Status = weekStatusErrored
+	default:
+		stats.Status = weekStatusComplete
+	}
+	patches := &WeeklyPatches{
+		Week:    week,
+		Repo:    repo,
+		Commits: patchCommits,
+	}
+
+	p.saveCommitRecords(repo, week, records, stats)
+
+	return stats, patches
+}
+
+// localCommitDetail fills in numstat line counts and files of a record from the
+// diff against the first parent, and returns the commit's patch hashes
+func localCommitDetail(c *object.Commit, record *CommitRecord) (PatchCommit, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return PatchCommit{}, err
+	}
+	var parentTree *object.Tree // nil for root commits, diffing against an empty tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return PatchCommit{}, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return PatchCommit{}, err
+		}
+	}
+
+	// Renames are detected like GitHub does, so a moved file isn't counted as
+	// rewritten
+	changes, err := object.DiffTreeWithOptions(context.Background(), parentTree, tree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return PatchCommit{}, err
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return PatchCommit{}, err
+	}
+
+	record.HasDetails = true
+	patchCommit := PatchCommit{
+		SHA:    record.SHA,
+		Author: record.Author,
+		Date:   record.AuthoredAt,
+	}
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		var path, previousPath string
+		switch {
+		case to != nil:
+			path = to.Path()
+			if from != nil && from.Path() != path {
+				previousPath = from.Path()
+			}
+		case from != nil:
+			path = from.Path()
+		}
+
+		// Binary files have no line counts, same as git's numstat. Mode-only and
+		// submodule changes and renames without changes have no chunks at all.
+		if fp.IsBinary() || len(fp.Chunks()) == 0 {
+			record.Files = append(record.Files, CommitFile{Path: path})
+			if previousPath != "" {
+				patchCommit.Files = append(patchCommit.Files, PatchFile{Path: path, PreviousPath: previousPath})
+			}
+			continue
+		}
+
+		// Rebuild a unified diff body so lines are hashed like GitHub patches
+		var body strings.Builder
+		file := CommitFile{Path: path}
+		for _, chunk := range fp.Chunks() {
+			var prefix string
+			switch chunk.Type() {
+			case diff.Add:
+				prefix = "+"
+			case diff.Delete:
+				prefix = "-"
+			default:
+				continue
+			}
+			if chunk.Content() == "" {
+				continue
+			}
+			lines := strings.Split(strings.TrimSuffix(chunk.Content(), "\n"), "\n")
+			for _, line := range lines {
+				body.WriteString(prefix + line + "\n")
+			}
+			if prefix == "+" {
+				file.Added += len(lines)
+			} else {
+				file.Removed += len(lines)
+			}
+		}
+		record.Files = append(record.Files, file)
+		record.Added += file.Added
+		record.Removed += file.Removed
+
+		added, removed := hashPatchLines(body.String())
+		patchCommit.Files = append(patchCommit.Files, PatchFile{
+			Path:         path,
+			PreviousPath: previousPath,
+			Added:        added,
+			Removed:      removed,
+		})
+	}
+
+	return patchCommit, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestCommit appends a line to main.go of a repository made by initTestRepo
+func addTestCommit(t *testing.T, dir, name, email string, date time.Time) {
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	file, err := os.OpenFile(filepath.Join(dir, "main.go"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString("line added by " + email + "\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	_, err = wt.Add("main.go")
+	require.NoError(t, err)
+
+	sig := &object.Signature{Name: name, Email: email, When: date}
+	_, err = wt.Commit("fix: change by "+name, &git.CommitOptions{Author: sig, Committer: sig})
+	require.NoError(t, err)
+}
+
+func TestFetchWeekFromLocalGit(t *testing.T) {
+	weekStart := weekToDate(testPastWeek)
+	dir := initTestRepo(t, "octocat", weekStart.Add(-24*time.Hour), weekStart.Add(24*time.Hour))
+	addTestCommit(t, dir, "Jane Doe", "Jane.Doe@Example.com", weekStart.Add(48*time.Hour))
+	addTestCommit(t, dir, "Jane Doe", "Jane.Doe@Example.com", weekStart.AddDate(0, 0, 7))
+	p, api, _ := newTestPlugin(t, dir)
+	expectCommitStorage(api)
+
+	stats, patches := p.fetchWeekFromLocalGit(testRepo, testPastWeek, dir)
+	require.NotNil(t, stats)
+	require.NotNil(t, patches)
+	assert.Equal(t, weekStatusComplete, stats.Status)
+
+	// The noreply address carries the login, other emails are lowercased;
+	// the commits of the weeks before and after are left out
+	require.Len(t, stats.Users, 2)
+	assert.Equal(t, 1, stats.Users["octocat"].Commits)
+	assert.Equal(t, 1, stats.Users["octocat"].Added)
+	assert.Equal(t, 1, stats.Users["jane.doe@example.com"].Commits)
+	assert.Equal(t, 1, stats.Users["jane.doe@example.com"].Added)
+
+	authors := make([]string, 0, len(patches.Commits))
+	for _, c := range patches.Commits {
+		authors = append(authors, c.Author)
+	}
+	assert.ElementsMatch(t, []string{"octocat", "jane.doe@example.com"}, authors)
+}
+
+func TestLocalCommitDetailDetectsRenames(t *testing.T) {
+	dir := initTestRepo(t, "octocat", weekToDate(testPastWeek))
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Move("main.go", "app.go")
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "octocat", Email: "octocat@example.com", When: weekToDate(testPastWeek).Add(time.Hour)}
+	hash, err := wt.Commit("refactor: rename", &git.CommitOptions{Author: sig, Committer: sig})
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(hash)
+	require.NoError(t, err)
+
+	// A moved file isn't counted as rewritten, and churn follows it
+	var record CommitRecord
+	patch, err := localCommitDetail(commit, &record)
+	require.NoError(t, err)
+	assert.Equal(t, 0, record.Added)
+	assert.Equal(t, 0, record.Removed)
+	assert.Equal(t, []CommitFile{{Path: "app.go"}}, record.Files)
+	assert.Equal(t, []PatchFile{{Path: "app.go", PreviousPath: "main.go"}}, patch.Files)
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// mappingSetVersion is the version of MappingSet written by this build
+//
+//	1: typed mappings
+//	2: email mappings in Email instead of GitHubLogin
+const mappingSetVersion = 2
+
+// legacyMappingsKey held the untyped mappings before MappingSet
+const legacyMappingsKey = "user_mappings"
+
+// mappingSourceProfile marks mappings derived from a Mattermost profile attribute
+const mappingSourceProfile = "profile"
+
+// mappingDateLayout is the layout of mapping validity dates, in UTC
+const mappingDateLayout = "2006-01-02"
+
+// UserMapping links a GitHub account, or a commit email, to a Mattermost user.
+// A user may have any number of mappings; their stats are merged. Commits
+// without a GitHub account are attributed to their author email. A mapping
+// with a validity period only applies to commits authored within it, so a
+// login can be mapped to different users over time.
+//
+// Commits are matched by login or email only. GitHubID records which account
+// held the login when it was mapped. It is informational, except that linking
+// a renamed account ends the mapping of its old login, and maps the new login
+// from then on.
+type UserMapping struct {
+	GitHubLogin string `json:"github_login,omitempty"`
+	GitHubID    int64  `json:"github_id,omitempty"`
+	Email       string `json:"email,omitempty"` // set instead of GitHubLogin
+	MMUserID    string `json:"mm_user_id"`
+	Source      string `json:"source,omitempty"`     // "" if set explicitly, or profile
+	ValidFrom   string `json:"valid_from,omitempty"` // first day, open if empty
+	ValidTo     string `json:"valid_to,omitempty"`   // last day, open if empty
+}
+
+// Identity returns the lowercased login or email the mapping matches
+func (m UserMapping) Identity() string {
+	if m.GitHubLogin != "" {
+		return strings.ToLower(m.GitHubLogin)
+	}
+	return strings.ToLower(m.Email)
+}
+
+// periodKey tells apart the mappings of one identity with different periods
+func (m UserMapping) periodKey() string {
+	return m.Identity() + "|" + m.ValidFrom
+}
+
+// validAt reports whether the mapping applies to a commit authored at t
+func (m UserMapping) validAt(t time.Time) bool {
+	day := t.UTC().Format(mappingDateLayout)
+	return (m.ValidFrom == "" || day >= m.ValidFrom) && (m.ValidTo == "" || day <= m.ValidTo)
+}
+
+// overlaps reports whether two mappings are valid on a common day
+func (m UserMapping) overlaps(other UserMapping) bool {
+	return (m.ValidFrom == "" || other.ValidTo == "" || m.ValidFrom <= other.ValidTo) &&
+		(other.ValidFrom == "" || m.ValidTo == "" || other.ValidFrom <= m.ValidTo)
+}
+
+// boundaries returns the times at which the mapping starts and stops applying
+func (m UserMapping) boundaries() []time.Time {
+	var times []time.Time
+	if t, err := time.Parse(mappingDateLayout, m.ValidFrom); err == nil {
+		times = append(times, t)
+	}
+	if t, err := time.Parse(mappingDateLayout, m.ValidTo); err == nil {
+		times = append(times, t.AddDate(0, 0, 1))
+	}
+	return times
+}
+
+// endBefore returns the mapping ending the day before day, which keeps its
+// history but stops it from applying from day on. ok is false if the mapping
+// starts on or after day, so there is no earlier period to keep.
+func (m UserMapping) endBefore(day string) (UserMapping, bool) {
+	if m.ValidFrom != "" && m.ValidFrom >= day {
+		return m, false
+	}
+	if previous := shiftDay(day, -1); m.ValidTo == "" || m.ValidTo > previous {
+		m.ValidTo = previous
+	}
+	return m, true
+}
+
+// fitPeriod fits a mapping without a period between the periods of others, the
+// other mappings of its identity: it starts after those that ended before day
+// and ends before those that start after it. ok is false if one of them
+// applies on day.
+func fitPeriod(m UserMapping, others []UserMapping, day string) (UserMapping, bool) {
+	for _, other := range others {
+		switch {
+		case other.ValidTo != "" && other.ValidTo < day:
+			if from := shiftDay(other.ValidTo, 1); from > m.ValidFrom {
+				m.ValidFrom = from
+			}
+		case other.ValidFrom > day:
+			if to := shiftDay(other.ValidFrom, -1); m.ValidTo == "" || to < m.ValidTo {
+				m.ValidTo = to
+			}
+		default:
+			return m, false
+		}
+	}
+	return m, true
+}
+
+// mappingDay returns today as a mapping date
+func mappingDay() string {
+	return time.Now().UTC().Format(mappingDateLayout)
+}
+
+// shiftDay moves a mapping date by days
+func shiftDay(day string, days int) string {
+	t, err := time.Parse(mappingDateLayout, day)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, days).Format(mappingDateLayout)
+}
+
+// normalize moves an email given as login to Email and trims all fields
+func (m *UserMapping) normalize() {
+	m.GitHubLogin = strings.TrimPrefix(strings.TrimSpace(m.GitHubLogin), "@")
+	m.Email = strings.ToLower(strings.TrimSpace(m.Email))
+	m.ValidFrom = strings.TrimSpace(m.ValidFrom)
+	m.ValidTo = strings.TrimSpace(m.ValidTo)
+	if strings.Contains(m.GitHubLogin, "@") && m.Email == "" {
+		m.Email = strings.ToLower(m.GitHubLogin)
+		m.GitHubLogin = ""
+	}
+}
+
+// UnresolvedMapping is a legacy entry that couldn't be migrated
+type UnresolvedMapping struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// errStaleMappings rejects a full save based on mappings that changed since
+var errStaleMappings = errors.New("mappings were changed since they were loaded")
+
+// MappingSet is the stored set of user mappings. Revision counts the saves, so
+// clients that send back a whole set can be checked for lost updates.
+type MappingSet struct {
+	Version    int                 `json:"version"`
+	Revision   int                 `json:"revision"`
+	Mappings   []UserMapping       `json:"mappings"`
+	Unresolved []UnresolvedMapping `json:"unresolved,omitempty"`
+}
+
+// getMappingSet returns the stored user mappings. The mapping store is the only
+// source, shared by all cluster nodes; legacy mappings are migrated into it the
+// first time. Migrations run under the mappings lock, so only one node writes
+// them.
+func (p *Plugin) getMappingSet() *MappingSet {
+	set, err := p.getStore().GetMappings()
+	if err != nil {
+		p.API.LogWarn("Failed to load user mappings", "error", err.Error())
+		return &MappingSet{Version: mappingSetVersion}
+	}
+	if set != nil && set.Version >= mappingSetVersion {
+		return set
+	}
+
+	unlock, err := p.getStore().LockMappings()
+	if err != nil {
+		p.API.LogWarn("Failed to lock user mappings for migration", "error", err.Error())
+		if set == nil {
+			return &MappingSet{Version: mappingSetVersion}
+		}
+		return set
+	}
+	defer unlock()
+
+	if set, err = p.loadMappingSet(); err != nil {
+		p.API.LogWarn("Failed to load user mappings", "error", err.Error())
+		return &MappingSet{Version: mappingSetVersion}
+	}
+	return set
+}
+
+// loadMappingSet returns the stored user mappings, migrating legacy mappings and
+// upgrading sets written by older versions. The caller holds the mappings lock.
+func (p *Plugin) loadMappingSet() (*MappingSet, error) {
+	set, err := p.getStore().GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return p.migrateLegacyMappings(), nil
+	}
+	if set.Version < mappingSetVersion {
+		before := append([]UserMapping(nil), set.Mappings...)
+		for i := range set.Mappings {
+			set.Mappings[i].normalize()
+		}
+		set.Version = mappingSetVersion
+		if err := p.getStore().SetMappings(set); err != nil {
+			p.API.LogWarn("Failed to upgrade user mappings", "error", err.Error())
+		} else {
+			p.recordMappingChanges(auditSystemActor, "upgrade", before, set.Mappings)
+		}
+	}
+	return set, nil
+}
+
+// getMappings returns the lowercased login or email -> MM user ID lookup of
+// the mappings valid today
+func (p *Plugin) getMappings() map[string]string {
+	index := p.getMappingIndex()
+	now := time.Now()
+	mappings := make(map[string]string)
+	for identity := range index {
+		if mmUserID := index.userAt(identity, now); mmUserID != "" {
+			mappings[identity] = mmUserID
+		}
+	}
+	return mappings
+}
+
+// mappingIndex holds the mappings of each lowercased login or email, so stats
+// can be attributed to the user an identity was mapped to at commit time
+type mappingIndex map[string][]UserMapping
+
+// getMappingIndex indexes the stored mappings by identity
+func (p *Plugin) getMappingIndex() mappingIndex {
+	index := make(mappingIndex)
+	for _, m := range p.getMappingSet().Mappings {
+		index[m.Identity()] = append(index[m.Identity()], m)
+	}
+	return index
+}
+
+// userAt returns the MM user the identity was mapped to at t, or ""
+func (index mappingIndex) userAt(identity string, t time.Time) string {
+	for _, m := range index[strings.ToLower(identity)] {
+		if m.validAt(t) {
+			return m.MMUserID
+		}
+	}
+	return ""
+}
+
+// ownerAt returns the key the stats of a login or commit email at t are merged
+// under: the MM user ID it was mapped to, or the identity itself
+func (index mappingIndex) ownerAt(identity string, t time.Time) string {
+	if mmUserID := index.userAt(identity, t); mmUserID != "" {
+		return mmUserID
+	}
+	return identity
+}
+
+// changesWithin reports whether the identity's mapping starts or ends after
+// from and before to, so stats between them can belong to several users
+func (index mappingIndex) changesWithin(identity string, from, to time.Time) bool {
+	for _, m := range index[strings.ToLower(identity)] {
+		for _, b := range m.boundaries() {
+			if b.After(from) && b.Before(to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ownedStat is the part of an identity's stats that is merged under one owner
+type ownedStat struct {
+	Owner    string
+	Identity string
+	Stat     WeekUserStat
+}
+
+// ownStats assigns stats per identity, covering start to end, to their owners.
+// Identities whose mapping changes within the stats' dates are split commit by
+// commit using records, loaded only then; without records an identity goes to
+// its owner at its first commit.
+func (index mappingIndex) ownStats(users map[string]WeekUserStat, start, end time.Time, records func() []CommitRecord) []ownedStat {
+	owned := make([]ownedStat, 0, len(users))
+	split := make(map[string]string) // lowercased identity -> key in users
+	for identity, stat := range users {
+		from, to := statPeriod(stat, start, end)
+		if index.changesWithin(identity, from, to) {
+			split[strings.ToLower(identity)] = identity
+			continue
+		}
+		owned = append(owned, ownedStat{Owner: index.ownerAt(identity, from), Identity: identity, Stat: stat})
+	}
+	if len(split) == 0 {
+		return owned
+	}
+
+	byOwner := make(map[string][]CommitRecord)
+	found := make(map[string]bool)
+	for _, r := range records() {
+		if r.Author == "" {
+			r.Author = emailAuthor(r.AuthorEmail)
+		}
+		identity, ok := split[strings.ToLower(r.Author)]
+		if !ok {
+			continue
+		}
+		r.Author = identity
+		at, _ := time.Parse(time.RFC3339, r.AuthoredAt)
+		owner := index.ownerAt(identity, at)
+		byOwner[owner] = append(byOwner[owner], r)
+		found[identity] = true
+	}
+	for owner, ownerRecords := range byOwner {
+		for identity, stat := range aggregateRecords(ownerRecords) {
+			owned = append(owned, ownedStat{Owner: owner, Identity: identity, Stat: stat})
+		}
+	}
+	for _, identity := range split {
+		if !found[identity] {
+			from, _ := statPeriod(users[identity], start, end)
+			owned = append(owned, ownedStat{Owner: index.ownerAt(identity, from), Identity: identity, Stat: users[identity]})
+		}
+	}
+	return owned
+}
+
+// statPeriod widens start and end, either of which may be zero, to the commit
+// dates of a stat. Commits are selected by committer date, so author dates can
+// fall outside the week.
+func statPeriod(stat WeekUserStat, start, end time.Time) (time.Time, time.Time) {
+	if first, err := time.Parse(time.RFC3339, stat.FirstCommit); err == nil && (start.IsZero() || first.Before(start)) {
+		start = first
+	}
+	if last, err := time.Parse(time.RFC3339, stat.LastCommit); err == nil && (end.IsZero() || !last.Before(end)) {
+		end = last.Add(time.Second)
+	}
+	return start, end
+}
+
+// sortedIdentities lists the identities merged into one stats row
+func sortedIdentities(identities map[string]bool) []string {
+	list := make([]string, 0, len(identities))
+	for identity := range identities {
+		list = append(list, identity)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// migrateLegacyMappings converts the untyped login -> user mappings, saved by
+// older versions in the KV store or else set in the System Console, into a
+// MappingSet. Values may be user IDs, usernames or emails and keys may be
+// emails; entries that can't be resolved are kept in the set's Unresolved list.
+// The caller holds the mappings lock.
+func (p *Plugin) migrateLegacyMappings() *MappingSet {
+	legacy := parseUserMappings(p.getConfiguration())
+	if data, appErr := p.API.KVGet(legacyMappingsKey); appErr == nil && data != nil {
+		saved := make(map[string]string)
+		if err := json.Unmarshal(data, &saved); err == nil {
+			legacy = saved
+		}
+	}
+
+	set := &MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{}}
+	for key, value := range legacy {
+		mapping, reason := p.resolveLegacyMapping(key, value)
+		if reason != "" {
+			set.Unresolved = append(set.Unresolved, UnresolvedMapping{Key: key, Value: value, Reason: reason})
+			p.API.LogWarn("Could not migrate user mapping", "key", key, "value", value, "reason", reason)
+			continue
+		}
+		set.Mappings = append(set.Mappings, mapping)
+	}
+	sortMappings(set)
+
+	if err := p.getStore().SetMappings(set); err != nil {
+		p.API.LogWarn("Failed to save migrated user mappings", "error", err.Error())
+		return set
+	}
+	p.API.KVDelete(legacyMappingsKey)
+	p.recordMappingChanges(auditSystemActor, "migration", nil, set.Mappings)
+	p.API.LogInfo("Migrated user mappings", "count", len(set.Mappings), "unresolved", len(set.Unresolved))
+	return set
+}
+
+// resolveLegacyMapping builds a typed mapping from a legacy entry, or returns
+// why it can't be resolved
+func (p *Plugin) resolveLegacyMapping(key, value string) (UserMapping, string) {
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if key == "" {
+		return UserMapping{}, "empty GitHub login"
+	}
+
+	mapping := UserMapping{GitHubLogin: key}
+	if strings.Contains(key, "@") {
+		// Noreply addresses carry the account; other emails match commits without one
+		email := strings.ToLower(key)
+		if m := noreplyEmailPattern.FindStringSubmatch(email); m != nil {
+			mapping.GitHubLogin = m[2]
+			mapping.GitHubID, _ = strconv.ParseInt(m[1], 10, 64)
+		} else {
+			mapping = UserMapping{Email: email}
+		}
+	}
+
+	user := p.findMMUser(value)
+	if user == nil {
+		return UserMapping{}, "no Mattermost user with this ID, username or email"
+	}
+	mapping.MMUserID = user.Id
+	return mapping, ""
+}
+
+// findMMUser looks a user up by ID, username or email
+func (p *Plugin) findMMUser(value string) *model.User {
+	if value == "" {
+		return nil
+	}
+	if model.IsValidId(value) {
+		if user, appErr := p.API.GetUser(value); appErr == nil {
+			return user
+		}
+	}
+	if strings.Contains(value, "@") {
+		if user, appErr := p.API.GetUserByEmail(value); appErr == nil {
+			return user
+		}
+	}
+	if user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@")); appErr == nil {
+		return user
+	}
+	return nil
+}
+
+// validatePeriod checks the validity dates of a normalized mapping
+func validatePeriod(m UserMapping) error {
+	for _, date := range []string{m.ValidFrom, m.ValidTo} {
+		if _, err := time.Parse(mappingDateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("invalid date %q for %s, use YYYY-MM-DD", date, m.Identity())
+		}
+	}
+	if m.ValidFrom != "" && m.ValidTo != "" && m.ValidFrom > m.ValidTo {
+		return fmt.Errorf("valid_from is after valid_to for %s", m.Identity())
+	}
+	return nil
+}
+
+// validateMappings checks mappings submitted through the API, normalizing them
+func (p *Plugin) validateMappings(mappings []UserMapping) error {
+	seen := make(map[string][]UserMapping)
+	for i := range mappings {
+		m := &mappings[i]
+		m.normalize()
+		if (m.GitHubLogin == "") == (m.Email == "") {
+			return fmt.Errorf("either github_login or email is required")
+		}
+		identity := m.Identity()
+		if err := validatePeriod(*m); err != nil {
+			return err
+		}
+		for _, other := range seen[identity] {
+			if m.overlaps(other) {
+				return fmt.Errorf("overlapping mappings of %s", identity)
+			}
+		}
+		seen[identity] = append(seen[identity], *m)
+		if m.Source != "" && m.Source != mappingSourceProfile {
+			return fmt.Errorf("invalid source for %s", identity)
+		}
+		if !model.IsValidId(m.MMUserID) {
+			return fmt.Errorf("invalid mm_user_id for %s", identity)
+		}
+		if _, appErr := p.API.GetUser(m.MMUserID); appErr != nil {
+			return fmt.Errorf("unknown mm_user_id for %s", identity)
+		}
+	}
+	return nil
+}
+
+// updateMappingSet applies fn to the stored mappings, saves them and records the
+// changes in the audit log under actorID. Nothing is saved if fn fails.
+// Unresolved legacy entries whose login got mapped are dropped.
+func (p *Plugin) updateMappingSet(actorID, source string, fn func(set *MappingSet) error) error {
+	unlock, err := p.getStore().LockMappings()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	set, err := p.loadMappingSet()
+	if err != nil {
+		return err
+	}
+	before := append([]UserMapping(nil), set.Mappings...)
+	if err := fn(set); err != nil {
+		return err
+	}
+
+	mapped := make(map[string]bool)
+	for _, m := range set.Mappings {
+		mapped[m.Identity()] = true
+	}
+	unresolved := set.Unresolved[:0]
+	for _, u := range set.Unresolved {
+		if !mapped[strings.ToLower(u.Key)] {
+			unresolved = append(unresolved, u)
+		}
+	}
+	set.Unresolved = unresolved
+	set.Version = mappingSetVersion
+	set.Revision++
+	sortMappings(set)
+	if err := p.getStore().SetMappings(set); err != nil {
+		return err
+	}
+	p.recordMappingChanges(actorID, source, before, set.Mappings)
+	return nil
+}
+
+// sortMappings orders a set by login and period so stored sets are stable
+func sortMappings(set *MappingSet) {
+	sort.Slice(set.Mappings, func(i, j int) bool {
+		a, b := set.Mappings[i], set.Mappings[j]
+		if a.Identity() != b.Identity() {
+			return a.Identity() < b.Identity()
+		}
+		return a.ValidFrom < b.ValidFrom
+	})
+	sort.Slice(set.Unresolved, func(i, j int) bool { return set.Unresolved[i].Key < set.Unresolved[j].Key })
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// maxImportBytes caps the size of an uploaded mapping file
+const maxImportBytes = 5 << 20
+
+// Import modes: replace makes the file the complete list of mappings, merge
+// adds and updates the file's mappings and keeps all others
+const (
+	importModeReplace = "replace"
+	importModeMerge   = "merge"
+)
+
+// mappingColumns are the CSV columns, in export order
+var mappingColumns = []string{"github_login", "github_id", "email", "mm_user_id", "mm_username", "valid_from", "valid_to"}
+
+// mappingRow is a mapping as exported and imported. On import the user is
+// given by mm_user_id or mm_username, which may also be an email.
+type mappingRow struct {
+	GitHubLogin string `json:"github_login,omitempty"`
+	GitHubID    int64  `json:"github_id,omitempty"`
+	Email       string `json:"email,omitempty"`
+	MMUserID    string `json:"mm_user_id,omitempty"`
+	MMUsername  string `json:"mm_username,omitempty"`
+	ValidFrom   string `json:"valid_from,omitempty"`
+	ValidTo     string `json:"valid_to,omitempty"`
+
+	line int // CSV line, or position in the JSON list
+}
+
+// ImportError is an invalid row of an import file
+type ImportError struct {
+	Line     int    `json:"line"`
+	Identity string `json:"identity,omitempty"`
+	Error    string `json:"error"`
+}
+
+// ImportResult is the outcome of an import. Nothing is applied if there are errors.
+type ImportResult struct {
+	DryRun  bool                `json:"dry_run"`
+	Applied bool                `json:"applied"`
+	Errors  []ImportError       `json:"errors"`
+	Changes []MappingAuditEntry `json:"changes"` // without actor and time
+	Created int                 `json:"created"`
+	Updated int                 `json:"updated"`
+	Deleted int                 `json:"deleted"`
+}
+
+// exportMappingRows lists the explicit mappings with the username of each user.
+// Mappings derived from profiles are left out; they follow the directory.
+func (p *Plugin) exportMappingRows() []mappingRow {
+	usernames := make(map[string]string)
+	mappings := p.getMappingSet().Mappings
+	rows := make([]mappingRow, 0, len(mappings))
+	for _, m := range mappings {
+		if m.Source == mappingSourceProfile {
+			continue
+		}
+		username, ok := usernames[m.MMUserID]
+		if !ok {
+			if user, appErr := p.API.GetUser(m.MMUserID); appErr == nil {
+				username = user.Username
+			}
+			usernames[m.MMUserID] = username
+		}
+		rows = append(rows, mappingRow{
+			GitHubLogin: m.GitHubLogin,
+			GitHubID:    m.GitHubID,
+			Email:       m.Email,
+			MMUserID:    m.MMUserID,
+			MMUsername:  username,
+			ValidFrom:   m.ValidFrom,
+			ValidTo:     m.ValidTo,
+		})
+	}
+	return rows
+}
+
+// writeMappingsCSV writes rows with a header line
+func writeMappingsCSV(w io.Writer, rows []mappingRow) error {
+	writer := csv.NewWriter(w)
+	writer.Write(mappingColumns)
+	for _, row := range rows {
+		githubID := ""
+		if row.GitHubID != 0 {
+			githubID = strconv.FormatInt(row.GitHubID, 10)
+		}
+		writer.Write([]string{row.GitHubLogin, githubID, row.Email, row.MMUserID, row.MMUsername, row.ValidFrom, row.ValidTo})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseMappingsCSV reads rows by header name, so columns may come in any order
+// and a spreadsheet's other columns are ignored. Rows that are malformed are
+// returned as errors; an unreadable file is an error.
+func parseMappingsCSV(r io.Reader) ([]mappingRow, []ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	_, hasLogin := columns["github_login"]
+	_, hasEmail := columns["email"]
+	_, hasUserID := columns["mm_user_id"]
+	_, hasUsername := columns["mm_username"]
+	if !(hasLogin || hasEmail) || !(hasUserID || hasUsername) {
+		return nil, nil, fmt.Errorf("header needs github_login or email, and mm_user_id or mm_username")
+	}
+
+	var rows []mappingRow
+	var errs []ImportError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := mappingRow{
+			GitHubLogin: field("github_login"),
+			Email:       field("email"),
+			MMUserID:    field("mm_user_id"),
+			MMUsername:  field("mm_username"),
+			ValidFrom:   field("valid_from"),
+			ValidTo:     field("valid_to"),
+			line:        line,
+		}
+		if row == (mappingRow{line: line}) && field("github_id") == "" {
+			continue // blank spreadsheet row
+		}
+		if v := field("github_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 0 {
+				errs = append(errs, ImportError{Line: line, Identity: row.GitHubLogin, Error: "invalid github_id"})
+				continue
+			}
+			row.GitHubID = id
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// parseMappingsJSON reads the export format, {"mappings": [...]}
+func parseMappingsJSON(r io.Reader) ([]mappingRow, error) {
+	var body struct {
+		Mappings []mappingRow `json:"mappings"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	for i := range body.Mappings {
+		body.Mappings[i].line = i + 1
+	}
+	return body.Mappings, nil
+}
+
+// resolveImportRows turns rows into mappings, reporting every row that names no
+// identity, overlaps the period of another row of it, or names an unknown or
+// deactivated Mattermost user
+func (p *Plugin) resolveImportRows(rows []mappingRow) ([]UserMapping, []ImportError) {
+	users := make(map[string]*model.User) // lookups by ID, username or email
+	findUser := func(value string) *model.User {
+		key := strings.ToLower(value)
+		if user, ok := users[key]; ok {
+			return user
+		}
+		user := p.findMMUser(value)
+		users[key] = user
+		return user
+	}
+
+	var mappings []UserMapping
+	var errs []ImportError
+	type seenRow struct {
+		mapping UserMapping
+		line    int
+	}
+	seen := make(map[string][]seenRow)
+	for _, row := range rows {
+		m := UserMapping{GitHubLogin: row.GitHubLogin, GitHubID: row.GitHubID, Email: row.Email, ValidFrom: row.ValidFrom, ValidTo: row.ValidTo}
+		m.normalize()
+		identity := m.Identity()
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, ImportError{Line: row.line, Identity: identity, Error: fmt.Sprintf(format, args...)})
+		}
+
+		if (m.GitHubLogin == "") == (m.Email == "") {
+			fail("either github_login or email is required")
+			continue
+		}
+		if err := validatePeriod(m); err != nil {
+			fail("%s", err.Error())
+			continue
+		}
+		overlapping := 0
+		for _, other := range seen[identity] {
+			if m.overlaps(other.mapping) {
+				overlapping = other.line
+				break
+			}
+		}
+		if overlapping != 0 {
+			fail("overlaps line %d", overlapping)
+			continue
+		}
+		seen[identity] = append(seen[identity], seenRow{mapping: m, line: row.line})
+
+		var user *model.User
+		switch {
+		case row.MMUserID != "":
+			if user = findUser(row.MMUserID); user == nil || user.Id != row.MMUserID {
+				fail("unknown Mattermost user ID %s", row.MMUserID)
+				continue
+			}
+			if row.MMUsername != "" {
+				if other := findUser(row.MMUsername); other == nil || other.Id != user.Id {
+					fail("mm_username %s is not user %s", row.MMUsername, row.MMUserID)
+					continue
+				}
+			}
+		case row.MMUsername != "":
+			if user = findUser(row.MMUsername); user == nil {
+				fail("unknown Mattermost user %s", row.MMUsername)
+				continue
+			}
+		default:
+			fail("mm_user_id or mm_username is required")
+			continue
+		}
+		if user.DeleteAt > 0 {
+			fail("Mattermost user %s is deactivated", user.Username)
+			continue
+		}
+
+		m.MMUserID = user.Id
+		mappings = append(mappings, m)
+	}
+	return mappings, errs
+}
+
+// applyImport returns the mappings after importing into current. Replacing
+// keeps the mappings derived from profiles that the file doesn't override.
+func applyImport(mode string, current, imported []UserMapping) []UserMapping {
+	replaced := make(map[string]bool, len(imported))
+	for _, m := range imported {
+		replaced[m.Identity()] = true
+	}
+	result := make([]UserMapping, 0, len(current)+len(imported))
+	for _, m := range current {
+		if !replaced[m.Identity()] && (mode == importModeMerge || m.Source == mappingSourceProfile) {
+			result = append(result, m)
+		}
+	}
+	return append(result, imported...)
+}
+
+// setChanges fills in the changes of an import and counts them
+func (result *ImportResult) setChanges(changes []MappingAuditEntry) {
+	result.Changes = changes
+	if result.Changes == nil {
+		result.Changes = []MappingAuditEntry{}
+	}
+	result.Created, result.Updated, result.Deleted = 0, 0, 0
+	for _, c := range changes {
+		switch c.Action {
+		case auditCreate:
+			result.Created++
+		case auditUpdate:
+			result.Updated++
+		case auditDelete:
+			result.Deleted++
+		}
+	}
+}
+
+// handleExportMappings downloads the mappings as JSON or, with format=csv, CSV (admin only)
+func (p *Plugin) handleExportMappings(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, `{"error": "format must be csv or json"}`, http.StatusBadRequest)
+		return
+	}
+
+	rows := p.exportMappingRows()
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="github-mappings.csv"`)
+		if err := writeMappingsCSV(w, rows); err != nil {
+			p.API.LogWarn("Failed to write mappings export", "error", err.Error())
+		}
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="github-mappings.json"`)
+	json.NewEncoder(w).Encode(map[string][]mappingRow{"mappings": rows})
+}
+
+// handleImportMappings validates an uploaded CSV or JSON file and, unless
+// dry_run=true, applies it in one step (admin only). Query: format (csv or
+// json, else taken from the Content-Type), mode (replace or merge), dry_run.
+func (p *Plugin) handleImportMappings(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = importModeReplace
+	}
+	if mode != importModeReplace && mode != importModeMerge {
+		http.Error(w, `{"error": "mode must be replace or merge"}`, http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = "csv"
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []mappingRow
+	var rowErrs []ImportError
+	var err error
+	switch format {
+	case "csv":
+		rows, rowErrs, err = parseMappingsCSV(body)
+	case "json":
+		rows, err = parseMappingsJSON(body)
+	default:
+		http.Error(w, `{"error": "format must be csv or json"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "invalid "+format+": "+err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if len(rows) == 0 && len(rowErrs) == 0 && mode == importModeReplace {
+		http.Error(w, `{"error": "the file has no mappings"}`, http.StatusBadRequest)
+		return
+	}
+
+	mappings, errs := p.resolveImportRows(rows)
+	result := &ImportResult{DryRun: query.Get("dry_run") == "true", Errors: append(rowErrs, errs...)}
+	if len(result.Errors) > 0 {
+		result.setChanges(nil)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	result.Errors = []ImportError{}
+
+	if result.DryRun {
+		current := p.getMappingSet().Mappings
+		result.setChanges(diffMappings(current, applyImport(mode, current, mappings)))
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	err = p.updateMappingSet(r.Header.Get("Mattermost-User-Id"), "import", func(set *MappingSet) error {
+		before := set.Mappings
+		set.Mappings = applyImport(mode, set.Mappings, mappings)
+		result.setChanges(diffMappings(before, set.Mappings))
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to save imported mappings", "error", err.Error())
+		http.Error(w, `{"error": "failed to save"}`, http.StatusInternalServerError)
+		return
+	}
+	result.Applied = true
+	p.API.LogInfo("Imported user mappings", "mode", mode, "created", result.Created, "updated", result.Updated, "deleted", result.Deleted)
+
+	json.NewEncoder(w).Encode(result)
+}
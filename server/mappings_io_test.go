@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportMappings(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+
+	admin := &model.User{Id: model.NewId(), Username: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId}
+	alice := &model.User{Id: model.NewId(), Username: "alice", Email: "alice@example.com"}
+	bob := &model.User{Id: model.NewId(), Username: "bob"}
+	gone := &model.User{Id: model.NewId(), Username: "gone", DeleteAt: 1}
+	notFound := model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+	for _, user := range []*model.User{admin, alice, bob, gone} {
+		api.On("GetUser", user.Id).Return(user, nil).Maybe()
+		api.On("GetUserByUsername", user.Username).Return(user, nil).Maybe()
+	}
+	api.On("GetUser", mock.Anything).Return(nil, notFound)
+	api.On("GetUserByUsername", mock.Anything).Return(nil, notFound)
+	api.On("GetUserByEmail", "alice@example.com").Return(alice, nil)
+	api.On("GetUserByEmail", mock.Anything).Return(nil, notFound)
+
+	existing := []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice.Id},
+		{GitHubLogin: "hubot", MMUserID: bob.Id},
+	}
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: existing}))
+
+	importCSV := func(query, body string) (int, ImportResult) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/mappings/import?"+query, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-Id", admin.Id)
+		r.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		p.handleImportMappings(w, r)
+		var result ImportResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	// Every invalid row is reported and nothing is applied
+	code, result := importCSV("", "team,github_login,email,mm_username\n"+
+		"core,octocat,,bob\n"+
+		"core,Octocat,,alice\n"+
+		"core,,dev@example.com,nobody\n"+
+		"core,ghost,,gone\n"+
+		",,,\n"+
+		"core,,,alice\n")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, []ImportError{
+		{Line: 3, Identity: "octocat", Error: "overlaps line 2"},
+		{Line: 4, Identity: "dev@example.com", Error: "unknown Mattermost user nobody"},
+		{Line: 5, Identity: "ghost", Error: "Mattermost user gone is deactivated"},
+		{Line: 7, Error: "either github_login or email is required"},
+	}, result.Errors)
+	assert.Equal(t, existing, p.getMappingSet().Mappings)
+
+	// A dry run shows the changes without saving them
+	valid := "github_login,github_id,email,mm_user_id,mm_username\n" +
+		"octocat,583231,,," + bob.Username + "\n" +
+		",,Dev@Example.com,,alice@example.com\n"
+	code, result = importCSV("dry_run=true", valid)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, result.Applied)
+	assert.Equal(t, []int{1, 1, 1}, []int{result.Created, result.Updated, result.Deleted})
+	assert.Equal(t, existing, p.getMappingSet().Mappings)
+
+	// Merging keeps the mappings missing from the file
+	api.On("LogInfo", "Imported user mappings", "mode", "merge", "created", 1, "updated", 1, "deleted", 0).Return().Once()
+	code, result = importCSV("mode=merge", valid)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Applied)
+	assert.Equal(t, []UserMapping{
+		{Email: "dev@example.com", MMUserID: alice.Id},
+		{GitHubLogin: "hubot", MMUserID: bob.Id},
+		{GitHubLogin: "octocat", GitHubID: 583231, MMUserID: bob.Id},
+	}, p.getMappingSet().Mappings)
+
+	audit, err := store.GetMappingAudit(auditDay(time.Now()))
+	require.NoError(t, err)
+	require.Len(t, audit, 2)
+	assert.Equal(t, "import", audit[0].Source)
+	assert.Equal(t, "admin", audit[0].ActorUsername)
+
+	// The export reads back as the same mappings
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/mappings/export?format=csv", nil)
+	r.Header.Set("Mattermost-User-Id", admin.Id)
+	w := httptest.NewRecorder()
+	p.handleExportMappings(w, r)
+	assert.Equal(t, "github_login,github_id,email,mm_user_id,mm_username,valid_from,valid_to\n"+
+		",,dev@example.com,"+alice.Id+",alice,,\n"+
+		"hubot,,,"+bob.Id+",bob,,\n"+
+		"octocat,583231,,"+bob.Id+",bob,,\n", w.Body.String())
+
+	api.On("LogInfo", "Imported user mappings", "mode", "replace", "created", 0, "updated", 0, "deleted", 0).Return().Once()
+	code, result = importCSV("", w.Body.String())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, result.Changes)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateLegacyMappings(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	kv := newFakeKV(api)
+	p := &Plugin{store: newMemoryStore()}
+	p.SetAPI(api)
+
+	alice := &model.User{Id: model.NewId(), Username: "alice", Email: "alice@example.com"}
+	bob := &model.User{Id: model.NewId(), Username: "bob"}
+	notFound := model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+	api.On("GetUser", alice.Id).Return(alice, nil)
+	api.On("GetUser", mock.Anything).Return(nil, notFound)
+	api.On("GetUserByUsername", "bob").Return(bob, nil)
+	api.On("GetUserByUsername", mock.Anything).Return(nil, notFound)
+	api.On("GetUserByEmail", "alice@example.com").Return(alice, nil)
+	api.On("GetUserByEmail", mock.Anything).Return(nil, notFound)
+	expectLogWarn(api, 3).Once()
+	api.On("LogInfo", "Migrated user mappings", "count", 4, "unresolved", 1).Return().Once()
+
+	// The System Console value is only used when nothing was saved through the API
+	p.setConfiguration(&configuration{UserMappings: `{"ignored": "bob"}`})
+	kv.setJSON(t, legacyMappingsKey, map[string]string{
+		"Octocat": alice.Id, // MM user ID
+		"hubot":   "bob",    // username
+		"12345+monalisa@users.noreply.github.com": "alice@example.com", // noreply email key, email value
+		"Dev@Example.com":                         "@bob",              // email of a local commit author
+		"ghost":                                   "nobody",
+	})
+
+	set := p.getMappingSet()
+	assert.Equal(t, []UserMapping{
+		{Email: "dev@example.com", MMUserID: bob.Id},
+		{GitHubLogin: "hubot", MMUserID: bob.Id},
+		{GitHubLogin: "monalisa", GitHubID: 12345, MMUserID: alice.Id},
+		{GitHubLogin: "Octocat", MMUserID: alice.Id},
+	}, set.Mappings)
+	require.Len(t, set.Unresolved, 1)
+	assert.Equal(t, "ghost", set.Unresolved[0].Key)
+	assert.NotContains(t, kv.keys(), legacyMappingsKey)
+
+	// Migrated once; lookups are case-insensitive
+	assert.Equal(t, alice.Id, p.getMappings()["octocat"])
+}
+
+func TestMigrateLegacyMappingsOnce(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	kv := newFakeKV(api)
+	alice := &model.User{Id: model.NewId(), Username: "alice"}
+	// Slow lookups keep the first migration running while the second node loads
+	api.On("GetUser", alice.Id).Return(alice, nil).After(50 * time.Millisecond)
+	api.On("LogInfo", "Migrated user mappings", "count", 1, "unresolved", 0).Return().Once()
+	kv.setJSON(t, legacyMappingsKey, map[string]string{"octocat": alice.Id})
+
+	// Two plugin instances activating at once like two cluster nodes
+	nodes := []*Plugin{{}, {}}
+	var wg sync.WaitGroup
+	for _, p := range nodes {
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{})
+		wg.Add(1)
+		go func(p *Plugin) {
+			defer wg.Done()
+			assert.Equal(t, []UserMapping{{GitHubLogin: "octocat", MMUserID: alice.Id}}, p.getMappingSet().Mappings)
+		}(p)
+	}
+	wg.Wait()
+
+	entries, err := nodes[0].getStore().GetMappingAudit(auditDay(time.Now()))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "migration", entries[0].Source)
+}
+
+func TestCollectStatsMergesIdentities(t *testing.T) {
+	p, api, store := newTestPlugin(t, t.TempDir())
+	expectCommitStorage(api)
+
+	jane := &model.User{Id: model.NewId(), Username: "jane", FirstName: "Jane", LastName: "Doe"}
+	api.On("GetUser", jane.Id).Return(jane, nil)
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "jane-work", MMUserID: jane.Id},
+		{GitHubLogin: "JaneOld", MMUserID: jane.Id},
+		{Email: "jane@home.example", MMUserID: jane.Id},
+	}}))
+	require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{
+		SchemaVersion: weeklyStatsSchemaVersion,
+		Repo:          testRepo,
+		Week:          testPastWeek,
+		Status:        weekStatusComplete,
+		Users: map[string]WeekUserStat{
+			"jane-work":         {Commits: 3, Added: 30},
+			"janeold":           {Commits: 1, Added: 5},
+			"jane@home.example": {Commits: 2, Added: 7, Removed: 1},
+			"octocat":           {Commits: 1},
+		},
+	}, 0))
+
+	stats := p.collectStats(p.getConfiguration(), testPastWeek, testPastWeek)
+	require.Len(t, stats.Users, 2)
+	assert.Equal(t, jane.Id, stats.Users[0].MMUserID)
+	assert.Equal(t, "Jane Doe", stats.Users[0].Name)
+	assert.Equal(t, 6, stats.Users[0].Commits)
+	assert.Equal(t, 42, stats.Users[0].Added)
+	assert.Equal(t, []string{"jane-work", "jane@home.example", "janeold"}, stats.Users[0].Identities)
+	assert.Equal(t, "octocat", stats.Users[1].Name)
+}
+
+func TestCollectStatsAppliesMappingPeriods(t *testing.T) {
+	p, api, store := newTestPlugin(t, t.TempDir())
+	newFakeKV(api)
+
+	alice := &model.User{Id: model.NewId(), Username: "alice"}
+	bob := &model.User{Id: model.NewId(), Username: "bob"}
+	api.On("GetUser", alice.Id).Return(alice, nil)
+	api.On("GetUser", bob.Id).Return(bob, nil)
+
+	// octocat changed hands in the middle of the week
+	mappings := []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice.Id, ValidTo: "2025-03-05"},
+		{GitHubLogin: "octocat", MMUserID: bob.Id, ValidFrom: "2025-03-06"},
+		{GitHubLogin: "hubot", MMUserID: bob.Id},
+	}
+	require.NoError(t, p.validateMappings(mappings))
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: mappings}))
+
+	commit := func(sha, author, at string, added int) CommitRecord {
+		return CommitRecord{SHA: sha, Repo: testRepo, Author: author, AuthoredAt: at, Added: added, Message: "feat: " + sha}
+	}
+	records := []CommitRecord{
+		commit("a", "octocat", "2025-03-03T10:00:00Z", 10),
+		commit("b", "octocat", "2025-03-05T23:59:00Z", 20),
+		commit("c", "octocat", "2025-03-07T09:00:00Z", 40),
+		commit("d", "hubot", "2025-03-04T09:00:00Z", 1),
+	}
+	weekStats := &WeeklyRepoStats{
+		SchemaVersion: weeklyStatsSchemaVersion,
+		Repo:          testRepo,
+		Week:          testPastWeek,
+		Status:        weekStatusComplete,
+		Users:         aggregateRecords(records),
+	}
+	p.saveCommitRecords(testRepo, testPastWeek, records, weekStats)
+	require.NoError(t, store.SetWeeklyStats(weekStats, 0))
+
+	stats := p.collectStats(p.getConfiguration(), testPastWeek, testPastWeek)
+	require.Len(t, stats.Users, 2)
+	byUser := make(map[string]UserStats)
+	for _, u := range stats.Users {
+		byUser[u.MMUserID] = u
+	}
+	assert.Equal(t, 2, byUser[alice.Id].Commits)
+	assert.Equal(t, 30, byUser[alice.Id].Added)
+	assert.Equal(t, []string{"octocat"}, byUser[alice.Id].Identities)
+	assert.Equal(t, 2, byUser[bob.Id].Commits)
+	assert.Equal(t, 41, byUser[bob.Id].Added)
+	assert.Equal(t, map[string]int{"feat": 2}, byUser[bob.Id].ByType)
+	assert.Equal(t, []string{"hubot", "octocat"}, byUser[bob.Id].Identities)
+
+	// Today octocat is bob's
+	assert.Equal(t, bob.Id, p.getMappings()["octocat"])
+
+	overlapping := []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice.Id, ValidTo: "2025-03-06"},
+		{GitHubLogin: "octocat", MMUserID: bob.Id, ValidFrom: "2025-03-06"},
+	}
+	assert.EqualError(t, p.validateMappings(overlapping), "overlapping mappings of octocat")
+}
+
+func TestUpdateMappingSetAcrossNodes(t *testing.T) {
+	api := &plugintest.API{}
+	kv := newFakeKV(api)
+	kv.setJSON(t, mappingsKey, MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{}})
+
+	// Two plugin instances share the KV store like two cluster nodes
+	nodes := []*Plugin{{}, {}}
+	for _, p := range nodes {
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for n, p := range nodes {
+			wg.Add(1)
+			go func(p *Plugin, login string) {
+				defer wg.Done()
+				assert.NoError(t, p.updateMappingSet(auditSystemActor, "api", func(set *MappingSet) error {
+					set.Mappings = append(set.Mappings, UserMapping{GitHubLogin: login, MMUserID: model.NewId()})
+					return nil
+				}))
+			}(p, fmt.Sprintf("user-%d-%d", n, i))
+		}
+	}
+	wg.Wait()
+
+	assert.Len(t, nodes[0].getMappingSet().Mappings, 10)
+}
+
+func TestSaveMappingsRejectsStaleSets(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+
+	admin := &model.User{Id: model.NewId(), Username: "admin", Roles: model.SystemAdminRoleId}
+	api.On("GetUser", admin.Id).Return(admin, nil)
+	api.On("GetUser", mock.Anything).Return(&model.User{}, nil)
+	alice, bob := model.NewId(), model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Revision: 3, Mappings: []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice},
+	}}))
+
+	save := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/mappings", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-Id", admin.Id)
+		w := httptest.NewRecorder()
+		p.handleSaveMappings(w, r)
+		return w
+	}
+	hubot := `{"github_login": "hubot", "mm_user_id": "` + bob + `"}`
+
+	w := save(`{"mappings": [` + hubot + `]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = save(`{"revision": 3, "mappings": [` + hubot + `]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result struct {
+		Revision int `json:"revision"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 4, result.Revision)
+	assert.Equal(t, 4, p.getMappingSet().Revision)
+
+	// A page loaded before that save can't overwrite it
+	w = save(`{"revision": 3, "mappings": []}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, []UserMapping{{GitHubLogin: "hubot", MMUserID: bob}}, p.getMappingSet().Mappings)
+}
@@ -2,28 +2,60 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"golang.org/x/sync/singleflight"
 )
 
 type Plugin struct {
 	plugin.MattermostPlugin
 	configurationLock sync.RWMutex
 	configuration     *configuration
+	syncJobLock       sync.Mutex
+	syncJob           *cluster.Job
+	reportJobLock     sync.Mutex
+	reportJob         *cluster.Job
+	profileJobLock    sync.Mutex
+	profileJob        *cluster.Job
+	storeLock         sync.Mutex
+	pendingLinks      sync.Map // user IDs with a device flow in progress
+	store             StatsStore
+	revalidating      sync.Map // repo-week keys with a background refresh in flight
+	fetchGroup        singleflight.Group
 }
 
 func (p *Plugin) OnActivate() error {
+	if err := p.API.RegisterCommand(getCommand()); err != nil {
+		return fmt.Errorf("failed to register command: %w", err)
+	}
+
+	// Entries written by older versions move to the current key scheme
+	go p.migrateKeyScheme()
+
+	// Migrates legacy mappings, or imports the System Console value, once
+	p.getMappingSet()
+
 	p.API.LogInfo("GitHub Activity Reports plugin activated")
 	return nil
 }
 
+func (p *Plugin) OnDeactivate() error {
+	p.stopSyncJob()
+	p.stopReportJob()
+	p.stopProfileSyncJob()
+	return nil
+}
+
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -39,6 +71,10 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 		p.handleGetConfig(w, r)
 	case "/api/v1/stats":
 		p.handleGetStats(w, r)
+	case "/api/v1/teams/stats":
+		p.handleGetTeamStats(w, r)
+	case "/api/v1/commits":
+		p.handleQueryCommits(w, r)
 	case "/api/v1/users":
 		p.handleGetUsers(w, r)
 	case "/api/v1/github/contributors":
@@ -51,6 +87,26 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 		} else {
 			p.handleGetMappings(w, r)
 		}
+	case "/api/v1/mappings/export":
+		p.handleExportMappings(w, r)
+	case "/api/v1/mappings/import":
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		p.handleImportMappings(w, r)
+	case "/api/v1/mappings/unmapped":
+		p.handleGetUnmapped(w, r)
+	case "/api/v1/mappings/audit":
+		p.handleGetMappingAudit(w, r)
+	case "/api/v1/mappings/suggestions":
+		p.handleGetSuggestions(w, r)
+	case "/api/v1/mappings/suggestions/accept":
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		p.handleAcceptSuggestions(w, r)
 	case "/api/v1/github/repo/validate":
 		p.handleValidateRepo(w, r)
 	case "/api/v1/github/all-contributors":
@@ -58,6 +114,14 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	case "/api/v1/github/contributors-with-commits":
 		p.handleGetContributorsWithCommits(w, r)
 	default:
+		if strings.HasPrefix(r.URL.Path, "/api/v1/repos/") {
+			p.handleRepoRoute(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/v1/admin/cache") {
+			p.handleCacheRoute(w, r)
+			return
+		}
 		http.NotFound(w, r)
 	}
 }
@@ -65,15 +129,9 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 func (p *Plugin) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	config := p.getConfiguration()
 
-	// Parse user mappings
-	mappings := make(map[string]string)
-	if config.UserMappings != "" {
-		json.Unmarshal([]byte(config.UserMappings), &mappings)
-	}
-
 	response := map[string]interface{}{
 		"repositories": config.Repositories,
-		"mappings":     mappings,
+		"mappings":     p.getMappings(),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -81,27 +139,46 @@ func (p *Plugin) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 
 // WeeklyRepoStats stores cached stats for a repo+week
 type WeeklyRepoStats struct {
-	Week      string                  `json:"week"`
-	Repo      string                  `json:"repo"`
-	Users     map[string]WeekUserStat `json:"users"` // github login -> stats
-	FetchedAt string                  `json:"fetched_at"`
+	SchemaVersion int                     `json:"schema_version"`
+	Week          string                  `json:"week"`
+	Repo          string                  `json:"repo"`
+	Users         map[string]WeekUserStat `json:"users"` // github login or author email -> stats
+	FetchedAt     string                  `json:"fetched_at"`
+	Status        string                  `json:"status"` // complete, truncated, errored or empty
+	// Commits whose line counts are missing (detail fetch limit or errors)
+	MissingDetails int `json:"missing_details,omitempty"`
+	// Why the week is partial: details that failed (part of MissingDetails),
+	// the commits page limit, or a commits page that failed
+	FailedDetails int  `json:"failed_details,omitempty"`
+	PageLimit     bool `json:"page_limit,omitempty"`
+	PageFailed    bool `json:"page_failed,omitempty"`
 }
 
 type WeekUserStat struct {
-	Commits int `json:"commits"`
-	Added   int `json:"added"`
-	Removed int `json:"removed"`
+	Commits     int    `json:"commits"`
+	Added       int    `json:"added"`
+	Removed     int    `json:"removed"`
+	FirstCommit string `json:"first_commit,omitempty"` // RFC3339 author date
+	LastCommit  string `json:"last_commit,omitempty"`
+	// Conventional Commits type -> commits, non-conventional commits count as "other"
+	Types    map[string]int `json:"types,omitempty"`
+	Breaking int            `json:"breaking,omitempty"`
 }
 
 // UserStats represents stats for a single user
 type UserStats struct {
-	MMUserID   string         `json:"mm_user_id"`
-	MMUsername string         `json:"mm_username"`
-	Name       string         `json:"name"`
-	Commits    int            `json:"commits"`
-	Added      int            `json:"added"`
-	Removed    int            `json:"removed"`
-	ByRepo     map[string]int `json:"by_repo"`
+	MMUserID     string         `json:"mm_user_id"`
+	MMUsername   string         `json:"mm_username"`
+	Name         string         `json:"name"`
+	Commits      int            `json:"commits"`
+	Added        int            `json:"added"`
+	Removed      int            `json:"removed"`
+	ByRepo       map[string]int `json:"by_repo"`
+	ChurnedLines int            `json:"churned_lines"`
+	ChurnRate    float64        `json:"churn_rate"`
+	ByType       map[string]int `json:"by_type"`
+	Breaking     int            `json:"breaking"`
+	Identities   []string       `json:"identities,omitempty"` // GitHub logins and commit emails merged into this row
 }
 
 // StatsResponse represents the stats response
@@ -111,66 +188,141 @@ type StatsResponse struct {
 	WeekStart   string      `json:"week_start"`
 	WeekEnd     string      `json:"week_end"`
 	LastUpdated string      `json:"last_updated"`
+	Warnings    []string    `json:"warnings,omitempty"` // partial weeks in the range
+	// Oldest current-week snapshot served, and whether fresher data is being fetched
+	CurrentWeekFetchedAt string `json:"current_week_fetched_at,omitempty"`
+	Refreshing           bool   `json:"refreshing,omitempty"`
 }
 
 func (p *Plugin) handleGetStats(w http.ResponseWriter, r *http.Request) {
 	config := p.getConfiguration()
-	if config.GitHubToken == "" {
-		http.Error(w, `{"error": "GitHub token not configured"}`, http.StatusBadRequest)
+	if !hasStatsSource(config) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
 		return
 	}
 
+	weekStart, weekEnd := weekRangeFromQuery(r)
+	response := p.collectStats(config, weekStart, weekEnd)
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// currentISOWeek returns the current ISO week (2026-W05)
+func currentISOWeek() string {
+	year, week := time.Now().ISOWeek()
+	return isoWeekString(year, week)
+}
+
+// isoWeekString formats a year and week number as an ISO week (2026-W05)
+func isoWeekString(year, week int) string {
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// weekRangeFromQuery reads week_start/week_end, defaulting to the last 4 weeks
+func weekRangeFromQuery(r *http.Request) (string, string) {
 	weekStart := r.URL.Query().Get("week_start")
 	weekEnd := r.URL.Query().Get("week_end")
 
-	now := time.Now()
-	currentYear, currentWeek := now.ISOWeek()
-	currentWeekStr := fmt.Sprintf("%d-W%02d", currentYear, currentWeek)
-
 	if weekStart == "" || weekEnd == "" {
+		currentYear, currentWeek := time.Now().ISOWeek()
 		weekStart = fmt.Sprintf("%d-W%02d", currentYear, currentWeek-4)
-		weekEnd = currentWeekStr
+		weekEnd = currentISOWeek()
 	}
+	return weekStart, weekEnd
+}
 
-	// Parse user mappings
+// parseUserMappings parses the untyped mappings of the System Console value. It
+// only seeds the mapping store; read getMappings instead.
+func parseUserMappings(config *configuration) map[string]string {
 	mappings := make(map[string]string)
 	if config.UserMappings != "" {
 		json.Unmarshal([]byte(config.UserMappings), &mappings)
 	}
+	return mappings
+}
+
+// trackedRepos returns the configured repositories as trimmed owner/repo names
+func trackedRepos(config *configuration) []string {
+	var repos []string
+	for _, repo := range strings.Split(config.Repositories, ",") {
+		repo = strings.TrimSpace(repo)
+		if repo != "" {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// shortRepoName strips the owner part from owner/repo
+func shortRepoName(repo string) string {
+	if idx := strings.Index(repo, "/"); idx >= 0 {
+		return repo[idx+1:]
+	}
+	return repo
+}
+
+// collectStats aggregates per-user stats over all tracked repos for a week range
+func (p *Plugin) collectStats(config *configuration, weekStart, weekEnd string) *StatsResponse {
+	currentWeekStr := currentISOWeek()
+	mappings := p.getMappingIndex()
 
-	repos := strings.Split(config.Repositories, ",")
-	
 	// Aggregate stats per user
 	userCommits := make(map[string]int)
 	userAdded := make(map[string]int)
 	userRemoved := make(map[string]int)
 	userByRepo := make(map[string]map[string]int)
+	userByType := make(map[string]map[string]int)
+	userBreaking := make(map[string]int)
 	activeRepos := make(map[string]bool)
+	userChurn := make(map[string]ChurnStat)
+	userIdentities := make(map[string]map[string]bool)
+	var warnings []string
+	var currentFetchedAt time.Time
+	refreshing := false
 
 	// Generate list of weeks to fetch
 	weeks := p.getWeeksInRange(weekStart, weekEnd)
+	windowDays := churnWindowDays(config)
 
-	for _, repo := range repos {
-		repo = strings.TrimSpace(repo)
-		if repo == "" {
-			continue
-		}
-
-		shortRepo := repo
-		if idx := strings.Index(repo, "/"); idx >= 0 {
-			shortRepo = repo[idx+1:]
+	for _, repo := range trackedRepos(config) {
+		shortRepo := shortRepoName(repo)
+		hasSource := repoHasSource(config, repo)
+		if !hasSource {
+			warnings = append(warnings, noSourceWarning(repo))
 		}
 
 		for _, week := range weeks {
 			weekStats := p.getWeeklyStats(repo, week, week == currentWeekStr, config.GitHubToken)
 			if weekStats == nil {
+				if hasSource {
+					warnings = append(warnings, fmt.Sprintf("%s %s: stats could not be fetched", repo, week))
+				}
 				continue
 			}
+			if warning := weekStats.Warning(); warning != "" {
+				warnings = append(warnings, warning)
+			}
+			if week == currentWeekStr {
+				if fetchedAt, err := time.Parse(time.RFC3339, weekStats.FetchedAt); err == nil && (currentFetchedAt.IsZero() || fetchedAt.Before(currentFetchedAt)) {
+					currentFetchedAt = fetchedAt
+				}
+				refreshing = refreshing || p.isRevalidating(repo, week)
+			}
 
-			for login, stat := range weekStats.Users {
+			// Logins and emails of the same MM user are merged into one row,
+			// using the mapping valid when each commit was authored
+			weekStartDate := weekToDate(week)
+			records := func() []CommitRecord { return p.loadCommitWeekRecords(repo, week) }
+			for _, owned := range mappings.ownStats(weekStats.Users, weekStartDate, weekStartDate.AddDate(0, 0, 7), records) {
+				identity, stat := owned.Identity, owned.Stat
 				if stat.Commits > 0 {
 					activeRepos[shortRepo] = true
 				}
+				login := owned.Owner
+				if userIdentities[login] == nil {
+					userIdentities[login] = make(map[string]bool)
+				}
+				userIdentities[login][identity] = true
 				userCommits[login] += stat.Commits
 				userAdded[login] += stat.Added
 				userRemoved[login] += stat.Removed
@@ -178,8 +330,17 @@ func (p *Plugin) handleGetStats(w http.ResponseWriter, r *http.Request) {
 					userByRepo[login] = make(map[string]int)
 				}
 				userByRepo[login][shortRepo] += stat.Commits
+				userByType[login] = addTypeCounts(userByType[login], stat.Types)
+				userBreaking[login] += stat.Breaking
 			}
 		}
+
+		for login, churn := range p.churnForRepo(repo, weeks, windowDays, mappings) {
+			total := userChurn[login]
+			total.Added += churn.Added
+			total.Churned += churn.Churned
+			userChurn[login] = total
+		}
 	}
 
 	// Build response with MM user info
@@ -188,55 +349,78 @@ func (p *Plugin) handleGetStats(w http.ResponseWriter, r *http.Request) {
 		if commits == 0 {
 			continue
 		}
-		mmUserID := mappings[ghLogin]
-		mmUsername := ""
-		name := ghLogin
-
-		if mmUserID != "" {
-			if user, err := p.API.GetUser(mmUserID); err == nil {
-				mmUsername = user.Username
-				if user.FirstName != "" || user.LastName != "" {
-					name = strings.TrimSpace(user.FirstName + " " + user.LastName)
-				} else if user.Nickname != "" {
-					name = user.Nickname
-				}
-			}
-		}
+		identities := sortedIdentities(userIdentities[ghLogin])
+		mmUserID, mmUsername, name := p.resolveMMUser(ghLogin, identities[0])
 
 		users = append(users, UserStats{
-			MMUserID:   mmUserID,
-			MMUsername: mmUsername,
-			Name:       name,
-			Commits:    commits,
-			Added:      userAdded[ghLogin],
-			Removed:    userRemoved[ghLogin],
-			ByRepo:     userByRepo[ghLogin],
+			MMUserID:     mmUserID,
+			MMUsername:   mmUsername,
+			Name:         name,
+			Commits:      commits,
+			Added:        userAdded[ghLogin],
+			Removed:      userRemoved[ghLogin],
+			ByRepo:       userByRepo[ghLogin],
+			ChurnedLines: userChurn[ghLogin].Churned,
+			ChurnRate:    userChurn[ghLogin].Rate(),
+			ByType:       userByType[ghLogin],
+			Breaking:     userBreaking[ghLogin],
+			Identities:   identities,
 		})
 	}
 
 	// Sort by commits desc
-	for i := 0; i < len(users); i++ {
-		for j := i + 1; j < len(users); j++ {
-			if users[j].Commits > users[i].Commits {
-				users[i], users[j] = users[j], users[i]
-			}
-		}
-	}
+	sortUserStats(users)
 
 	var reposList []string
 	for r := range activeRepos {
 		reposList = append(reposList, r)
 	}
 
-	response := StatsResponse{
+	response := &StatsResponse{
 		Users:       users,
 		Repos:       reposList,
 		WeekStart:   weekStart,
 		WeekEnd:     weekEnd,
 		LastUpdated: time.Now().Format(time.RFC3339),
+		Warnings:    warnings,
+		Refreshing:  refreshing,
+	}
+	if !currentFetchedAt.IsZero() {
+		response.CurrentWeekFetchedAt = currentFetchedAt.Format(time.RFC3339)
 	}
+	return response
+}
 
-	json.NewEncoder(w).Encode(response)
+// resolveMMUser returns the MM user ID, username and display name of a stats
+// owner: the MM user ID of mapped identities, or else the GitHub login or
+// commit email itself
+func (p *Plugin) resolveMMUser(owner, identity string) (string, string, string) {
+	mmUserID := ""
+	if owner != identity {
+		mmUserID = owner
+	}
+	mmUsername := ""
+	name := identity
+
+	if mmUserID != "" {
+		if user, err := p.API.GetUser(mmUserID); err == nil {
+			mmUsername = user.Username
+			if user.FirstName != "" || user.LastName != "" {
+				name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			} else if user.Nickname != "" {
+				name = user.Nickname
+			}
+		}
+	}
+
+	return mmUserID, mmUsername, name
+}
+
+// sortUserStats sorts users by commits desc
+func sortUserStats(users []UserStats) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Commits > users[j].Commits
+	})
 }
 
 // getWeeksInRange returns list of ISO weeks between start and end
@@ -268,129 +452,392 @@ func (p *Plugin) nextWeek(week string) string {
 	return fmt.Sprintf("%d-W%02d", year, wn)
 }
 
-// getWeeklyStats gets stats for a repo+week, using cache for past weeks
+// getWeeklyStats gets stats for a repo+week, using cache for past weeks. The
+// current week is served from its last snapshot, revalidated in the background
+// once stale; it is only fetched inline when there is no snapshot yet.
 func (p *Plugin) getWeeklyStats(repo, week string, isCurrentWeek bool, token string) *WeeklyRepoStats {
-	cacheKey := fmt.Sprintf("gh_stats_%s_%s", strings.ReplaceAll(repo, "/", "_"), week)
-
-	// Try cache for past weeks
-	if !isCurrentWeek {
-		if data, err := p.API.KVGet(cacheKey); err == nil && data != nil {
-			var cached WeeklyRepoStats
-			if json.Unmarshal(data, &cached) == nil {
-				return &cached
+	// Taken before reading the cache so a fetch finishing in between is reused
+	requestedAt := time.Now()
+
+	if isCurrentWeek {
+		if snapshot := p.loadCurrentWeekSnapshot(repo, week); snapshot != nil {
+			if p.snapshotStale(snapshot) {
+				p.revalidateCurrentWeek(repo, week, token)
 			}
+			return snapshot
 		}
+		return p.refreshWeeklyStatsSince(repo, week, true, token, requestedAt)
+	}
+
+	// Try cache for past weeks, upgrading outdated entries lazily
+	cached := p.loadCachedWeeklyStats(repo, week)
+	if cached != nil && !cached.Outdated() {
+		return cached
+	}
+	if p.recentlyFailed(repo, week) {
+		return cached
 	}
+	if stats := p.refreshWeeklyStatsSince(repo, week, false, token, requestedAt); stats != nil {
+		return stats
+	}
+
+	// Outdated data beats none when GitHub is unavailable
+	return cached
+}
 
-	// Fetch from GitHub
-	stats := p.fetchWeekFromGitHub(repo, week, token)
+// loadCachedWeeklyStats returns the cached stats for a repo+week, or nil
+func (p *Plugin) loadCachedWeeklyStats(repo, week string) *WeeklyRepoStats {
+	cached, err := p.getStore().GetWeeklyStats(repo, week)
+	if err != nil {
+		return nil
+	}
+	return cached
+}
+
+// recentlyFailed reports whether the last fetch of a repo+week failed less than
+// fetchRetryDelay ago, so GitHub outages don't turn every request into a refetch
+func (p *Plugin) recentlyFailed(repo, week string) bool {
+	return p.failedWithin(repo, week, fetchRetryDelay)
+}
+
+// failedWithin reports whether the last fetch of a repo+week failed less than d ago
+func (p *Plugin) failedWithin(repo, week string, d time.Duration) bool {
+	meta, err := p.getStore().GetFetchMeta(repo, week)
+	if err != nil || meta == nil || !meta.Failed {
+		return false
+	}
+	attemptAt, parseErr := time.Parse(time.RFC3339, meta.LastAttemptAt)
+	return parseErr == nil && time.Since(attemptAt) < d
+}
+
+// fetchAndStoreWeek fetches a repo+week from its local clone or GitHub and caches
+// it, the current week as a snapshot only. Callers go through refreshWeeklyStats
+// so each repo-week is fetched once across the cluster.
+func (p *Plugin) fetchAndStoreWeek(repo, week string, isCurrentWeek bool, token string) *WeeklyRepoStats {
+	var stats *WeeklyRepoStats
+	var patches *WeeklyPatches
+	source := "github"
+	if path, ok := localRepoPath(p.getConfiguration(), repo); ok {
+		source = "local"
+		stats, patches = p.fetchWeekFromLocalGit(repo, week, path)
+	} else {
+		stats, patches = p.fetchWeekFromGitHub(repo, week, token)
+	}
 	if stats == nil {
+		p.recordFetch(repo, week, source, false)
 		return nil
 	}
 
+	// Patch data backs the churn metric; keep it alongside the stats
+	p.saveWeeklyPatches(patches)
+
 	// Cache if not current week
-	if !isCurrentWeek && len(stats.Users) > 0 {
-		if data, err := json.Marshal(stats); err == nil {
-			p.API.KVSet(cacheKey, data)
-		}
+	if isCurrentWeek {
+		p.saveCurrentWeekSnapshot(stats)
+	} else {
+		p.cacheWeeklyStats(stats)
 	}
 
+	p.recordFetch(repo, week, source, true)
 	return stats
 }
 
-// fetchWeekFromGitHub fetches commit stats for a specific week
-func (p *Plugin) fetchWeekFromGitHub(repo, week, token string) *WeeklyRepoStats {
+// recordFetch stores a failed fetch attempt. A success is only written when
+// it clears a recorded failure, so successful fetches cost no extra write.
+func (p *Plugin) recordFetch(repo, week, source string, ok bool) {
+	store := p.getStore()
+	meta, _ := store.GetFetchMeta(repo, week)
+	if ok && (meta == nil || !meta.Failed) {
+		return
+	}
+	if meta == nil {
+		meta = &FetchMeta{Repo: repo, Week: week}
+	}
+	now := time.Now().Format(time.RFC3339)
+	meta.Source = source
+	meta.LastAttemptAt = now
+	meta.Failed = !ok
+	if ok {
+		meta.LastSuccessAt = now
+	}
+	if err := store.SetFetchMeta(meta); err != nil {
+		p.API.LogWarn("Failed to save fetch metadata", "repo", repo, "week", week, "error", err.Error())
+	}
+}
+
+// cacheWeeklyStats stores a past week. Only complete weeks are cached permanently;
+// empty weeks get a negative-cache entry and partial weeks expire, errored ones
+// soon so they are retried.
+func (p *Plugin) cacheWeeklyStats(stats *WeeklyRepoStats) {
+	if err := p.getStore().SetWeeklyStats(stats, weekStatsTTL(stats.Status)); err != nil {
+		p.API.LogWarn("Failed to cache weekly stats", "repo", stats.Repo, "week", stats.Week, "error", err.Error())
+	}
+}
+
+// fetchWeekFromGitHub fetches commit stats and per-file patch hashes for a specific week
+func (p *Plugin) fetchWeekFromGitHub(repo, week, token string) (*WeeklyRepoStats, *WeeklyPatches) {
 	startDate := weekToDate(week)
 	endDate := startDate.AddDate(0, 0, 7)
 
 	client := &http.Client{Timeout: 30 * time.Second}
-	
-	commitsURL := fmt.Sprintf(
-		"https://api.github.com/repos/%s/commits?since=%s&until=%s&per_page=100",
-		repo,
-		startDate.Format(time.RFC3339),
-		endDate.Format(time.RFC3339),
-	)
-
-	req, _ := http.NewRequest("GET", commitsURL, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		p.API.LogWarn("GitHub API error", "repo", repo, "week", week, "error", err.Error())
-		return nil
+	var commits []GitHubCommit
+	pageLimit, pageFailed := false, false
+	for page := 1; ; page++ {
+		commitsURL := fmt.Sprintf(
+			"%s/repos/%s/commits?since=%s&until=%s&per_page=%d&page=%d",
+			githubAPIURL,
+			repo,
+			startDate.Format(time.RFC3339),
+			endDate.Format(time.RFC3339),
+			commitsPerPage,
+			page,
+		)
+
+		resp, err := client.Do(newGitHubRequest(commitsURL, token))
+		if err != nil {
+			p.API.LogWarn("GitHub API error", "repo", repo, "week", week, "error", err.Error())
+			return nil, nil
+		}
+
+		var pageCommits []GitHubCommit
+		if resp.StatusCode == 200 {
+			err = json.NewDecoder(resp.Body).Decode(&pageCommits)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 || err != nil {
+			// A failed first page means no data at all; later pages leave a partial week
+			if page == 1 {
+				return nil, nil
+			}
+			pageFailed = true
+			break
+		}
+
+		commits = append(commits, pageCommits...)
+		if len(pageCommits) < commitsPerPage {
+			break
+		}
+		if page == maxCommitPages {
+			pageLimit = true
+			break
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil
+	agg := p.aggregateCommits(client, repo, token, commits)
+
+	stats := &WeeklyRepoStats{
+		SchemaVersion:  weeklyStatsSchemaVersion,
+		Week:           week,
+		Repo:           repo,
+		Users:          agg.Users,
+		FetchedAt:      time.Now().Format(time.RFC3339),
+		MissingDetails: agg.DetailsSkipped + agg.DetailsFailed,
+		FailedDetails:  agg.DetailsFailed,
+		PageLimit:      pageLimit,
+		PageFailed:     pageFailed,
+	}
+	switch {
+	case len(commits) == 0:
+		stats.Status = weekStatusEmpty
+	case agg.DetailsFailed > 0 || pageFailed:
+		stats.Status = weekStatusErrored
+	case pageLimit || agg.DetailsSkipped > 0:
+		stats.Status = weekStatusTruncated
+	default:
+		stats.Status = weekStatusComplete
 	}
+	patches := &WeeklyPatches{
+		Week:    week,
+		Repo:    repo,
+		Commits: agg.Patches,
+	}
+
+	p.saveCommitRecords(repo, week, agg.Records, stats)
+
+	return stats, patches
+}
 
-	var commits []struct {
-		SHA    string `json:"sha"`
-		Author *struct {
-			Login string `json:"login"`
+// GitHubCommit is a commit as returned by the commits list and compare APIs
+type GitHubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+			Date  string `json:"date"`
 		} `json:"author"`
+		Committer struct {
+			Date string `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+	Author *struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+// maxDetailFetches limits commit detail fetches per aggregation to avoid the rate limit
+const maxDetailFetches = 50
+
+// CommitAggregate is the result of aggregating a list of commits per GitHub login
+type CommitAggregate struct {
+	Users   map[string]WeekUserStat
+	Records []CommitRecord
+	Patches []PatchCommit
+	// Commits without line counts because of the detail fetch limit or fetch errors
+	DetailsSkipped int
+	DetailsFailed  int
+}
+
+// aggregateCommits normalizes commits into records, fetching line counts and patch
+// hashes for up to maxDetailFetches of them, and sums the records per GitHub login
+func (p *Plugin) aggregateCommits(client *http.Client, repo, token string, commits []GitHubCommit) *CommitAggregate {
+	agg := &CommitAggregate{}
+
+	for _, c := range commits {
+		record := CommitRecord{
+			SHA:         c.SHA,
+			Repo:        repo,
+			AuthorName:  c.Commit.Author.Name,
+			AuthorEmail: c.Commit.Author.Email,
+			AuthoredAt:  c.Commit.Author.Date,
+			CommittedAt: c.Commit.Committer.Date,
+			Message:     c.Commit.Message,
+		}
+		if c.Author != nil {
+			record.Author = c.Author.Login
+		} else {
+			record.Author = emailAuthor(c.Commit.Author.Email)
+		}
+
+		// Only attributed commits count, so only they are worth a detail fetch
+		if record.Author == "" {
+			agg.Records = append(agg.Records, record)
+			continue
+		}
+		if len(agg.Patches)+agg.DetailsFailed >= maxDetailFetches {
+			agg.DetailsSkipped++
+			agg.Records = append(agg.Records, record)
+			continue
+		}
+
+		patchCommit, ok := p.fetchCommitDetail(client, repo, token, &record)
+		if !ok {
+			agg.DetailsFailed++
+		} else {
+			agg.Patches = append(agg.Patches, patchCommit)
+		}
+		agg.Records = append(agg.Records, record)
 	}
-	json.NewDecoder(resp.Body).Decode(&commits)
 
-	stats := &WeeklyRepoStats{
-		Week:      week,
-		Repo:      repo,
-		Users:     make(map[string]WeekUserStat),
-		FetchedAt: time.Now().Format(time.RFC3339),
+	agg.Users = aggregateRecords(agg.Records)
+	return agg
+}
+
+// fetchCommitDetail fills in line counts and files of a record and returns the
+// commit's patch hashes
+func (p *Plugin) fetchCommitDetail(client *http.Client, repo, token string, record *CommitRecord) (PatchCommit, bool) {
+	detailURL := fmt.Sprintf("%s/repos/%s/commits/%s", githubAPIURL, repo, record.SHA)
+	detailResp, err := client.Do(newGitHubRequest(detailURL, token))
+	if err != nil {
+		return PatchCommit{}, false
+	}
+	defer detailResp.Body.Close()
+	if detailResp.StatusCode != 200 {
+		return PatchCommit{}, false
 	}
 
-	// First pass: count commits per user
-	userCommitSHAs := make(map[string][]string)
-	for _, c := range commits {
-		if c.Author == nil || c.Author.Login == "" {
+	var detail struct {
+		Stats struct {
+			Additions int `json:"additions"`
+			Deletions int `json:"deletions"`
+		} `json:"stats"`
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+			Additions        int    `json:"additions"`
+			Deletions        int    `json:"deletions"`
+			Patch            string `json:"patch"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(detailResp.Body).Decode(&detail); err != nil {
+		return PatchCommit{}, false
+	}
+
+	record.HasDetails = true
+	record.Added = detail.Stats.Additions
+	record.Removed = detail.Stats.Deletions
+
+	patchCommit := PatchCommit{
+		SHA:    record.SHA,
+		Author: record.Author,
+		Date:   record.AuthoredAt,
+	}
+	for _, f := range detail.Files {
+		record.Files = append(record.Files, CommitFile{
+			Path:    f.Filename,
+			Added:   f.Additions,
+			Removed: f.Deletions,
+		})
+
+		// GitHub omits the patch for binary and very large files, and for
+		// renames without changes, which are kept so churn follows the file
+		if f.Patch == "" && f.PreviousFilename == "" {
 			continue
 		}
-		login := c.Author.Login
-		userCommitSHAs[login] = append(userCommitSHAs[login], c.SHA)
+		added, removed := hashPatchLines(f.Patch)
+		patchCommit.Files = append(patchCommit.Files, PatchFile{
+			Path:         f.Filename,
+			PreviousPath: f.PreviousFilename,
+			Added:        added,
+			Removed:      removed,
+		})
 	}
 
-	// Second pass: fetch line counts for each commit (limit to avoid rate limit)
-	totalFetched := 0
-	maxDetailFetches := 50 // Limit detail fetches per week
+	return patchCommit, true
+}
 
-	for login, shas := range userCommitSHAs {
-		s := WeekUserStat{Commits: len(shas)}
-		
-		for _, sha := range shas {
-			if totalFetched >= maxDetailFetches {
-				break
-			}
-			
-			detailURL := fmt.Sprintf("https://api.github.com/repos/%s/commits/%s", repo, sha)
-			detailReq, _ := http.NewRequest("GET", detailURL, nil)
-			detailReq.Header.Set("Authorization", "Bearer "+token)
-			detailReq.Header.Set("Accept", "application/vnd.github+json")
-
-			detailResp, err := client.Do(detailReq)
-			if err == nil && detailResp.StatusCode == 200 {
-				var detail struct {
-					Stats struct {
-						Additions int `json:"additions"`
-						Deletions int `json:"deletions"`
-					} `json:"stats"`
-				}
-				json.NewDecoder(detailResp.Body).Decode(&detail)
-				s.Added += detail.Stats.Additions
-				s.Removed += detail.Stats.Deletions
-				totalFetched++
+// aggregateRecords sums commit records per GitHub login or, for commits without
+// an account, author email. Commits without either are skipped.
+func aggregateRecords(records []CommitRecord) map[string]WeekUserStat {
+	users := make(map[string]WeekUserStat)
+	for _, r := range records {
+		// Records stored before email attribution have no author
+		if r.Author == "" {
+			r.Author = emailAuthor(r.AuthorEmail)
+		}
+		if r.Author == "" {
+			continue
+		}
+
+		s := users[r.Author]
+		s.Commits++
+		s.Added += r.Added
+		s.Removed += r.Removed
+
+		// RFC3339 UTC dates compare correctly as strings
+		if date := r.AuthoredAt; date != "" {
+			if s.FirstCommit == "" || date < s.FirstCommit {
+				s.FirstCommit = date
 			}
-			if detailResp != nil {
-				detailResp.Body.Close()
+			if date > s.LastCommit {
+				s.LastCommit = date
 			}
 		}
-		
-		stats.Users[login] = s
-	}
 
-	return stats
+		cc := parseConventionalCommit(r.Message)
+		if s.Types == nil {
+			s.Types = make(map[string]int)
+		}
+		s.Types[cc.Type]++
+		if cc.Breaking {
+			s.Breaking++
+		}
+
+		users[r.Author] = s
+	}
+	return users
 }
 
 // weekToDate converts ISO week (2026-W05) to first day of that week
@@ -415,17 +862,14 @@ func weekToDate(isoWeek string) time.Time {
 
 func (p *Plugin) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 	// Get all MM users that have GitHub mappings
-	config := p.getConfiguration()
-	
-	mappings := make(map[string]string)
-	if config.UserMappings != "" {
-		json.Unmarshal([]byte(config.UserMappings), &mappings)
-	}
-
-	// Get MM users
+	seen := make(map[string]bool)
 	var users []*model.User
-	for _, mmUsername := range mappings {
-		user, err := p.API.GetUserByUsername(mmUsername)
+	for _, mmUserID := range p.getMappings() {
+		if seen[mmUserID] {
+			continue
+		}
+		seen[mmUserID] = true
+		user, err := p.API.GetUser(mmUserID)
 		if err == nil && user != nil {
 			users = append(users, user)
 		}
@@ -446,6 +890,7 @@ func (p *Plugin) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 
 // GitHubContributor represents a GitHub user/contributor
 type GitHubContributor struct {
+	ID        int64  `json:"id"`
 	Login     string `json:"login"`
 	AvatarURL string `json:"avatar_url"`
 	Name      string `json:"name"`
@@ -673,8 +1118,8 @@ func (p *Plugin) handleGetGitHubContributors(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(result)
 }
 
-// handleGetMattermostUsers returns all MM users for mapping dropdown (including inactive and bots)
-func (p *Plugin) handleGetMattermostUsers(w http.ResponseWriter, r *http.Request) {
+// listAllUsers returns all MM users, including inactive users and bots
+func (p *Plugin) listAllUsers() []*model.User {
 	page := 0
 	perPage := 200
 	var allUsers []*model.User
@@ -698,6 +1143,12 @@ func (p *Plugin) handleGetMattermostUsers(w http.ResponseWriter, r *http.Request
 		}
 		page++
 	}
+	return allUsers
+}
+
+// handleGetMattermostUsers returns all MM users for mapping dropdown (including inactive and bots)
+func (p *Plugin) handleGetMattermostUsers(w http.ResponseWriter, r *http.Request) {
+	allUsers := p.listAllUsers()
 
 	// Return simplified user data with avatar URLs
 	type MMUser struct {
@@ -731,59 +1182,68 @@ func (p *Plugin) handleGetMattermostUsers(w http.ResponseWriter, r *http.Request
 
 // handleGetMappings returns current user mappings
 func (p *Plugin) handleGetMappings(w http.ResponseWriter, r *http.Request) {
-	config := p.getConfiguration()
-	
-	mappings := make(map[string]string)
-	if config.UserMappings != "" {
-		json.Unmarshal([]byte(config.UserMappings), &mappings)
-	}
-
-	json.NewEncoder(w).Encode(mappings)
+	json.NewEncoder(w).Encode(p.getMappingSet())
 }
 
-// handleSaveMappings saves user mappings (admin only)
+// handleSaveMappings replaces the user mappings (admin only). The body must
+// carry the revision of the set it was based on; a save based on mappings that
+// changed since, e.g. by a link or another admin, is rejected with 409.
 func (p *Plugin) handleSaveMappings(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Mattermost-User-Id")
-	
-	// Check if user is admin
-	user, err := p.API.GetUser(userID)
-	if err != nil {
-		http.Error(w, `{"error": "failed to get user"}`, http.StatusInternalServerError)
-		return
-	}
-	if !user.IsSystemAdmin() {
-		http.Error(w, `{"error": "admin only"}`, http.StatusForbidden)
+	if !p.requireSystemAdmin(w, r) {
 		return
 	}
 
-	var mappings map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&mappings); err != nil {
+	var body struct {
+		Mappings   []UserMapping       `json:"mappings"`
+		Unresolved []UnresolvedMapping `json:"unresolved"`
+		Revision   *int                `json:"revision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
 		return
 	}
-
-	// Serialize and save to KV store
-	data, _ := json.Marshal(mappings)
-	
-	// Update plugin config via API
-	config := p.getConfiguration()
-	config.UserMappings = string(data)
-	
-	// Save to KV as backup/primary storage
-	if err := p.API.KVSet("user_mappings", data); err != nil {
+	if body.Revision == nil {
+		http.Error(w, `{"error": "revision is required"}`, http.StatusBadRequest)
+		return
+	}
+	if body.Mappings == nil {
+		body.Mappings = []UserMapping{}
+	}
+	if err := p.validateMappings(body.Mappings); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	var revision int
+	err := p.updateMappingSet(r.Header.Get("Mattermost-User-Id"), "api", func(stored *MappingSet) error {
+		if stored.Revision != *body.Revision {
+			return errStaleMappings
+		}
+		stored.Mappings = body.Mappings
+		stored.Unresolved = body.Unresolved
+		revision = stored.Revision + 1
+		return nil
+	})
+	if errors.Is(err, errStaleMappings) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	}
+	if err != nil {
 		p.API.LogError("Failed to save mappings", "error", err.Error())
 		http.Error(w, `{"error": "failed to save"}`, http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "revision": revision})
 }
 
 // ContributorCommit represents a single commit
 type ContributorCommit struct {
-	SHA     string `json:"sha"`
-	Message string `json:"message"`
-	Date    string `json:"date"`
+	SHA      string `json:"sha"`
+	Message  string `json:"message"`
+	Date     string `json:"date"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Breaking bool   `json:"breaking,omitempty"`
 }
 
 // ContributorWithCommits represents a contributor with their recent commits per repo
@@ -901,6 +1361,8 @@ func (p *Plugin) handleGetContributorsWithCommits(w http.ResponseWriter, r *http
 				continue
 			}
 
+			cc := parseConventionalCommit(c.Commit.Message)
+
 			// Truncate message to first line
 			msg := c.Commit.Message
 			if idx := strings.Index(msg, "\n"); idx > 0 {
@@ -921,9 +1383,12 @@ func (p *Plugin) handleGetContributorsWithCommits(w http.ResponseWriter, r *http
 			}
 
 			authorCommits[login] = append(authorCommits[login], ContributorCommit{
-				SHA:     sha,
-				Message: msg,
-				Date:    date,
+				SHA:      sha,
+				Message:  msg,
+				Date:     date,
+				Type:     cc.Type,
+				Scope:    cc.Scope,
+				Breaking: cc.Breaking,
 			})
 		}
 
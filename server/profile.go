@@ -0,0 +1,205 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	profileSyncJobKey   = "github_reports_profile_sync"
+	profileSyncInterval = time.Hour
+)
+
+// githubLoginPattern matches valid GitHub logins
+var githubLoginPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`)
+
+// profileLogins returns the GitHub logins in the user's profile attribute: the
+// nickname or position field, or else the user prop of that name, as filled in
+// by an LDAP or SAML sync. Several logins may be separated by commas or spaces;
+// @ prefixes and github.com profile URLs are accepted.
+func profileLogins(config *configuration, user *model.User) []string {
+	attribute := strings.TrimSpace(config.ProfileAttribute)
+	var value string
+	switch strings.ToLower(attribute) {
+	case "":
+		return nil
+	case "nickname":
+		value = user.Nickname
+	case "position":
+		value = user.Position
+	default:
+		value = user.Props[attribute]
+	}
+
+	var logins []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		field = strings.TrimSuffix(field, "/")
+		if i := strings.LastIndex(field, "github.com/"); i >= 0 {
+			field = field[i+len("github.com/"):]
+		}
+		field = strings.TrimPrefix(field, "@")
+		if githubLoginPattern.MatchString(field) {
+			logins = append(logins, field)
+		}
+	}
+	return logins
+}
+
+// mergeProfileMappings updates the profile mappings selected by replace to
+// desired, keeping their history: a current mapping that is still desired is
+// left alone, one that isn't ends yesterday, and a new one starts today.
+// Explicit mappings win: a new mapping is fitted around the periods of the
+// login's other mappings and skipped if one of them applies today.
+func mergeProfileMappings(current, desired []UserMapping, replace func(m UserMapping) bool) []UserMapping {
+	today := mappingDay()
+	wanted := make(map[string]bool, len(desired))
+	for _, m := range desired {
+		wanted[m.Identity()+"|"+m.MMUserID] = true
+	}
+
+	result := make([]UserMapping, 0, len(current)+len(desired))
+	kept := make(map[string][]UserMapping, len(current))
+	for _, m := range current {
+		if m.Source == mappingSourceProfile && replace(m) && (m.ValidTo == "" || m.ValidTo >= today) {
+			key := m.Identity() + "|" + m.MMUserID
+			if wanted[key] {
+				delete(wanted, key)
+			} else {
+				ended, ok := m.endBefore(today)
+				if !ok {
+					continue
+				}
+				m = ended
+			}
+		}
+		result = append(result, m)
+		kept[m.Identity()] = append(kept[m.Identity()], m)
+	}
+	for _, m := range desired {
+		if !wanted[m.Identity()+"|"+m.MMUserID] {
+			continue
+		}
+		if fitted, ok := fitPeriod(m, kept[m.Identity()], today); ok {
+			fitted.ValidFrom = today
+			result = append(result, fitted)
+			kept[m.Identity()] = append(kept[m.Identity()], fitted)
+		}
+	}
+	return result
+}
+
+// syncProfileMappings derives mappings from the profile attribute of every
+// user, ending the earlier profile mappings that no longer match. Logins
+// claimed by the profiles of several users are left unmapped. With no
+// attribute configured this ends all profile mappings.
+func (p *Plugin) syncProfileMappings() {
+	config := p.getConfiguration()
+
+	claims := make(map[string][]UserMapping)
+	if config.ProfileAttribute != "" {
+		for _, user := range p.listAllUsers() {
+			if user.IsBot {
+				continue
+			}
+			for _, login := range profileLogins(config, user) {
+				m := UserMapping{GitHubLogin: login, MMUserID: user.Id, Source: mappingSourceProfile}
+				claims[m.Identity()] = append(claims[m.Identity()], m)
+			}
+		}
+	}
+	desired := make([]UserMapping, 0, len(claims))
+	for identity, mappings := range claims {
+		if len(mappings) > 1 {
+			p.API.LogWarn("GitHub login is in several profiles", "login", identity, "users", len(mappings))
+			continue
+		}
+		desired = append(desired, mappings[0])
+	}
+
+	err := p.updateMappingSet(auditSystemActor, mappingSourceProfile, func(set *MappingSet) error {
+		set.Mappings = mergeProfileMappings(set.Mappings, desired, func(UserMapping) bool { return true })
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to save profile mappings", "error", err.Error())
+	}
+}
+
+// refreshProfileMappings updates the profile mappings of one user
+func (p *Plugin) refreshProfileMappings(user *model.User) {
+	config := p.getConfiguration()
+	if config.ProfileAttribute == "" || user.IsBot {
+		return
+	}
+
+	var desired []UserMapping
+	for _, login := range profileLogins(config, user) {
+		desired = append(desired, UserMapping{GitHubLogin: login, MMUserID: user.Id, Source: mappingSourceProfile})
+	}
+	ofUser := func(m UserMapping) bool { return m.MMUserID == user.Id }
+
+	// Hooks run on every login; only write when something changed
+	current := p.getMappingSet().Mappings
+	if len(diffMappings(current, mergeProfileMappings(current, desired, ofUser))) == 0 {
+		return
+	}
+	err := p.updateMappingSet(auditSystemActor, mappingSourceProfile, func(set *MappingSet) error {
+		set.Mappings = mergeProfileMappings(set.Mappings, desired, ofUser)
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to save profile mappings", "user_id", user.Id, "error", err.Error())
+	}
+}
+
+// UserHasBeenCreated maps new users from their profile attribute
+func (p *Plugin) UserHasBeenCreated(c *plugin.Context, user *model.User) {
+	p.refreshProfileMappings(user)
+}
+
+// UserHasLoggedIn picks up profile changes, e.g. from an LDAP sync at login
+func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
+	p.refreshProfileMappings(user)
+}
+
+// setupProfileSyncJob (re)schedules the periodic sync of profile mappings,
+// which catches attribute changes of users who don't log in. It runs on one
+// cluster node at a time.
+func (p *Plugin) setupProfileSyncJob() {
+	p.profileJobLock.Lock()
+	defer p.profileJobLock.Unlock()
+
+	if p.profileJob != nil {
+		if err := p.profileJob.Close(); err != nil {
+			p.API.LogWarn("Failed to stop profile sync job", "error", err.Error())
+		}
+		p.profileJob = nil
+	}
+
+	if p.getConfiguration().ProfileAttribute == "" {
+		return
+	}
+
+	job, err := cluster.Schedule(p.API, profileSyncJobKey, cluster.MakeWaitForInterval(profileSyncInterval), p.syncProfileMappings)
+	if err != nil {
+		p.API.LogError("Failed to schedule profile sync job", "error", err.Error())
+		return
+	}
+	p.profileJob = job
+}
+
+// stopProfileSyncJob stops the profile sync job, if running
+func (p *Plugin) stopProfileSyncJob() {
+	p.profileJobLock.Lock()
+	defer p.profileJobLock.Unlock()
+
+	if p.profileJob != nil {
+		p.profileJob.Close()
+		p.profileJob = nil
+	}
+}
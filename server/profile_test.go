@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileLogins(t *testing.T) {
+	user := &model.User{Nickname: "jd", Position: "@jane-doe", Props: model.StringMap{"github": "https://github.com/JaneDoe/, jd-old; not_valid!"}}
+	assert.Nil(t, profileLogins(&configuration{}, user))
+	assert.Equal(t, []string{"jane-doe"}, profileLogins(&configuration{ProfileAttribute: "Position"}, user))
+	assert.Equal(t, []string{"JaneDoe", "jd-old"}, profileLogins(&configuration{ProfileAttribute: "github"}, user))
+}
+
+func TestSyncProfileMappings(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{ProfileAttribute: "github"})
+
+	jane := &model.User{Id: model.NewId(), Props: model.StringMap{"github": "jane, jane-old"}}
+	bob := &model.User{Id: model.NewId(), Props: model.StringMap{"github": "octocat"}}
+	eve := &model.User{Id: model.NewId(), Props: model.StringMap{"github": "shared"}}
+	mallory := &model.User{Id: model.NewId(), Props: model.StringMap{"github": "shared"}}
+	bot := &model.User{Id: model.NewId(), IsBot: true, Props: model.StringMap{"github": "hubot"}}
+	api.On("GetUsers", mock.Anything).Return([]*model.User{jane, bob, eve, mallory, bot}, nil).Once()
+	api.On("LogWarn", "GitHub login is in several profiles", "login", "shared", "users", 2).Return().Once()
+
+	// An explicit mapping outranks a profile
+	alice := model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice},
+	}}))
+
+	today := mappingDay()
+	p.syncProfileMappings()
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "jane", MMUserID: jane.Id, Source: mappingSourceProfile, ValidFrom: today},
+		{GitHubLogin: "jane-old", MMUserID: jane.Id, Source: mappingSourceProfile, ValidFrom: today},
+		{GitHubLogin: "octocat", MMUserID: alice},
+	}, p.getMappingSet().Mappings)
+
+	// A changed attribute is picked up at login; a mapping that started today
+	// has no history to keep
+	jane.Props["github"] = "jane"
+	p.UserHasLoggedIn(nil, jane)
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "jane", MMUserID: jane.Id, Source: mappingSourceProfile, ValidFrom: today},
+		{GitHubLogin: "octocat", MMUserID: alice},
+	}, p.getMappingSet().Mappings)
+
+	audit, err := store.GetMappingAudit(auditDay(time.Now()))
+	require.NoError(t, err)
+	require.Len(t, audit, 3)
+	assert.Equal(t, auditDelete, audit[2].Action)
+	assert.Equal(t, "jane-old", audit[2].Identity)
+	assert.Equal(t, mappingSourceProfile, audit[2].Source)
+
+	// Without an attribute the profile mappings go away
+	p.setConfiguration(&configuration{})
+	p.syncProfileMappings()
+	assert.Equal(t, []UserMapping{{GitHubLogin: "octocat", MMUserID: alice}}, p.getMappingSet().Mappings)
+}
+
+func TestMergeProfileMappings(t *testing.T) {
+	alice, bob, carol := model.NewId(), model.NewId(), model.NewId()
+	current := []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: bob},
+		{GitHubLogin: "octocat", MMUserID: alice, ValidTo: "2024-12-31"},
+		{GitHubLogin: "monalisa", MMUserID: alice, ValidFrom: "2999-01-01"},
+	}
+	desired := []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: carol, Source: mappingSourceProfile},
+		{GitHubLogin: "octocat", MMUserID: carol, Source: mappingSourceProfile},
+		{GitHubLogin: "monalisa", MMUserID: carol, Source: mappingSourceProfile},
+	}
+
+	// The profile maps a login from today on, while no explicit mapping applies
+	today := mappingDay()
+	assert.Equal(t, append(current,
+		UserMapping{GitHubLogin: "octocat", MMUserID: carol, Source: mappingSourceProfile, ValidFrom: today},
+		UserMapping{GitHubLogin: "monalisa", MMUserID: carol, Source: mappingSourceProfile, ValidFrom: today, ValidTo: "2998-12-31"},
+	), mergeProfileMappings(current, desired, func(UserMapping) bool { return true }))
+}
+
+func TestMergeProfileMappingsKeepsHistory(t *testing.T) {
+	alice, bob := model.NewId(), model.NewId()
+	today := mappingDay()
+	yesterday := shiftDay(today, -1)
+	current := []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice, Source: mappingSourceProfile, ValidFrom: "2024-01-01"},
+		{GitHubLogin: "hubot", MMUserID: alice, Source: mappingSourceProfile},
+		{GitHubLogin: "monalisa", MMUserID: alice, Source: mappingSourceProfile, ValidTo: "2024-06-30"},
+	}
+	// octocat moved from Alice's profile to Bob's; hubot is still Alice's
+	desired := []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: bob, Source: mappingSourceProfile},
+		{GitHubLogin: "hubot", MMUserID: alice, Source: mappingSourceProfile},
+	}
+
+	merged := mergeProfileMappings(current, desired, func(UserMapping) bool { return true })
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "octocat", MMUserID: alice, Source: mappingSourceProfile, ValidFrom: "2024-01-01", ValidTo: yesterday},
+		{GitHubLogin: "hubot", MMUserID: alice, Source: mappingSourceProfile},
+		{GitHubLogin: "monalisa", MMUserID: alice, Source: mappingSourceProfile, ValidTo: "2024-06-30"},
+		{GitHubLogin: "octocat", MMUserID: bob, Source: mappingSourceProfile, ValidFrom: today},
+	}, merged)
+
+	// Past commits stay with Alice, new ones go to Bob
+	index := make(mappingIndex)
+	for _, m := range merged {
+		index[m.Identity()] = append(index[m.Identity()], m)
+	}
+	assert.Equal(t, alice, index.userAt("octocat", time.Now().AddDate(0, 0, -7)))
+	assert.Equal(t, bob, index.userAt("octocat", time.Now()))
+
+	// A second sync changes nothing
+	assert.Equal(t, merged, mergeProfileMappings(merged, desired, func(UserMapping) bool { return true }))
+}
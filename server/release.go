@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const latestRelease = "latest"
+
+// ReleaseCommit represents a commit between two tags
+type ReleaseCommit struct {
+	SHA      string `json:"sha"`
+	Message  string `json:"message"`
+	Author   string `json:"author"`
+	Date     string `json:"date"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Breaking bool   `json:"breaking,omitempty"`
+}
+
+// MergedPR represents a pull request merged between two tags
+type MergedPR struct {
+	Number   int    `json:"number"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	URL      string `json:"url"`
+	MergedAt string `json:"merged_at"`
+}
+
+// ReleaseReportResponse represents the activity between two tags of a repository
+type ReleaseReportResponse struct {
+	Repo         string          `json:"repo"`
+	FromTag      string          `json:"from_tag"`
+	ToTag        string          `json:"to_tag"`
+	FromDate     string          `json:"from_date"` // committer dates of the tags' commits
+	ToDate       string          `json:"to_date"`
+	Commits      []ReleaseCommit `json:"commits"`
+	TotalCommits int             `json:"total_commits"`
+	Truncated    bool            `json:"truncated"` // compare API lists at most 250 commits
+	// Commits whose line counts are missing (detail fetch limit or errors)
+	MissingDetails int         `json:"missing_details"`
+	Added          int         `json:"added"`
+	Removed        int         `json:"removed"`
+	Contributors   []UserStats `json:"contributors"`
+	MergedPRs      []MergedPR  `json:"merged_prs"`
+	PRsTruncated   bool        `json:"prs_truncated"` // some merged PRs could not be listed
+	LastUpdated    string      `json:"last_updated"`
+}
+
+// handleGetReleaseReport reports commits, contributors, line changes and merged PRs
+// between two tags: /api/v1/repos/{owner}/{repo}/release?from=v1.0.0&to=latest.
+// to defaults to the latest release, from defaults to the release before to.
+func (p *Plugin) handleGetReleaseReport(w http.ResponseWriter, r *http.Request, repoName string) {
+	config := p.getConfiguration()
+	if config.GitHubToken == "" {
+		http.Error(w, `{"error": "GitHub token not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	repo, tracked := findTrackedRepo(config, repoName)
+	if !tracked {
+		http.Error(w, `{"error": "repository is not tracked"}`, http.StatusNotFound)
+		return
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	token := config.GitHubToken
+
+	fromTag, toTag, err := p.resolveReleaseTags(client, repo, token, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	compare, status, err := p.fetchCompare(client, repo, token, fromTag, toTag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), status)
+		return
+	}
+
+	response := ReleaseReportResponse{
+		Repo:         repo,
+		FromTag:      fromTag,
+		ToTag:        toTag,
+		FromDate:     compare.BaseCommit.Commit.Committer.Date,
+		Commits:      make([]ReleaseCommit, 0, len(compare.Commits)),
+		TotalCommits: compare.TotalCommits,
+		Truncated:    compare.TotalCommits > len(compare.Commits),
+		Contributors: make([]UserStats, 0),
+		MergedPRs:    make([]MergedPR, 0),
+	}
+
+	for _, c := range compare.Commits {
+		author := ""
+		if c.Author != nil {
+			author = c.Author.Login
+		}
+		cc := parseConventionalCommit(c.Commit.Message)
+		msg := c.Commit.Message
+		if idx := strings.Index(msg, "\n"); idx > 0 {
+			msg = msg[:idx]
+		}
+		response.Commits = append(response.Commits, ReleaseCommit{
+			SHA:      c.SHA,
+			Message:  msg,
+			Author:   author,
+			Date:     c.Commit.Author.Date,
+			Type:     cc.Type,
+			Scope:    cc.Scope,
+			Breaking: cc.Breaking,
+		})
+		// Merges are committed, so committer dates bound the merged PRs; author
+		// dates can be much older than the merge
+		if c.Commit.Committer.Date > response.ToDate {
+			response.ToDate = c.Commit.Committer.Date
+		}
+	}
+	if response.Truncated {
+		// The head commit may be past the commits listed
+		if date := p.fetchCommitterDate(client, repo, token, toTag); date > response.ToDate {
+			response.ToDate = date
+		}
+	}
+
+	// Same aggregation and login mapping as the weekly stats
+	agg := p.aggregateCommits(client, repo, token, compare.Commits)
+	response.MissingDetails = agg.DetailsSkipped + agg.DetailsFailed
+	mappings := p.getMappingIndex()
+	shortRepo := shortRepoName(repo)
+	byOwner := make(map[string]*UserStats)
+	identities := make(map[string]map[string]bool)
+	records := func() []CommitRecord { return agg.Records }
+	for _, owned := range mappings.ownStats(agg.Users, time.Time{}, time.Time{}, records) {
+		owner, identity, stat := owned.Owner, owned.Identity, owned.Stat
+		response.Added += stat.Added
+		response.Removed += stat.Removed
+
+		u := byOwner[owner]
+		if u == nil {
+			u = &UserStats{ByRepo: make(map[string]int)}
+			byOwner[owner] = u
+			identities[owner] = make(map[string]bool)
+		}
+		identities[owner][identity] = true
+		u.Commits += stat.Commits
+		u.Added += stat.Added
+		u.Removed += stat.Removed
+		u.ByRepo[shortRepo] += stat.Commits
+		u.ByType = addTypeCounts(u.ByType, stat.Types)
+		u.Breaking += stat.Breaking
+	}
+	for owner, u := range byOwner {
+		u.Identities = sortedIdentities(identities[owner])
+		u.MMUserID, u.MMUsername, u.Name = p.resolveMMUser(owner, u.Identities[0])
+		response.Contributors = append(response.Contributors, *u)
+	}
+	sortUserStats(response.Contributors)
+
+	if response.FromDate != "" && response.ToDate >= response.FromDate {
+		response.MergedPRs, response.PRsTruncated = p.fetchMergedPRs(client, repo, token, response.FromDate, response.ToDate)
+	}
+	response.LastUpdated = time.Now().Format(time.RFC3339)
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// Compare paging. The compare API lists at most 250 commits of a range.
+const (
+	compareCommitsPerPage = 100
+	maxCompareCommits     = 250
+)
+
+// releaseCompare is the comparison of two tags
+type releaseCompare struct {
+	TotalCommits int `json:"total_commits"`
+	BaseCommit   struct {
+		Commit struct {
+			Committer struct {
+				Date string `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	} `json:"base_commit"`
+	Commits []GitHubCommit `json:"commits"`
+}
+
+// fetchCompare compares two tags, paging through the listed commits. On error it
+// returns the HTTP status to respond with. A failed later page leaves the
+// commits read so far, which the report flags as truncated.
+func (p *Plugin) fetchCompare(client *http.Client, repo, token, fromTag, toTag string) (*releaseCompare, int, error) {
+	var compare *releaseCompare
+	for page := 1; ; page++ {
+		compareURL := fmt.Sprintf("%s/repos/%s/compare/%s...%s?per_page=%d&page=%d", githubAPIURL, repo, url.PathEscape(fromTag), url.PathEscape(toTag), compareCommitsPerPage, page)
+		next, status, err := p.fetchComparePage(client, compareURL, token)
+		if err != nil {
+			if compare == nil {
+				return nil, status, err
+			}
+			p.API.LogWarn("Failed to fetch compare page", "repo", repo, "page", page, "error", err.Error())
+			break
+		}
+		if compare == nil {
+			compare = next
+		} else {
+			compare.Commits = append(compare.Commits, next.Commits...)
+		}
+		if len(next.Commits) < compareCommitsPerPage || len(compare.Commits) >= min(compare.TotalCommits, maxCompareCommits) {
+			break
+		}
+	}
+	return compare, http.StatusOK, nil
+}
+
+// fetchComparePage fetches one page of a comparison
+func (p *Plugin) fetchComparePage(client *http.Client, compareURL, token string) (*releaseCompare, int, error) {
+	resp, err := client.Do(newGitHubRequest(compareURL, token))
+	if err != nil {
+		return nil, http.StatusBadGateway, errors.New("failed to connect to GitHub")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, http.StatusNotFound, errors.New("tag not found")
+	}
+	if resp.StatusCode != 200 {
+		return nil, http.StatusBadGateway, fmt.Errorf("GitHub API error: %d", resp.StatusCode)
+	}
+
+	var compare releaseCompare
+	if err := json.NewDecoder(resp.Body).Decode(&compare); err != nil {
+		return nil, http.StatusBadGateway, errors.New("failed to parse GitHub response")
+	}
+	return &compare, http.StatusOK, nil
+}
+
+// resolveReleaseTags turns the from/to parameters into concrete tag names.
+// "latest" (or an empty to) resolves to the latest release; an empty from
+// resolves to the release published before to.
+func (p *Plugin) resolveReleaseTags(client *http.Client, repo, token, from, to string) (string, string, error) {
+	if to != "" && to != latestRelease && from != "" && from != latestRelease {
+		return from, to, nil
+	}
+
+	releasesURL := fmt.Sprintf("%s/repos/%s/releases?per_page=100", githubAPIURL, repo)
+	resp, err := client.Do(newGitHubRequest(releasesURL, token))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to connect to GitHub")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("failed to list releases: %d", resp.StatusCode)
+	}
+
+	// Releases are listed newest first
+	var releases []struct {
+		TagName    string `json:"tag_name"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	}
+	json.NewDecoder(resp.Body).Decode(&releases)
+
+	var tags []string
+	for _, rel := range releases {
+		if !rel.Draft && !rel.Prerelease {
+			tags = append(tags, rel.TagName)
+		}
+	}
+	if len(tags) == 0 {
+		return "", "", fmt.Errorf("repository has no releases")
+	}
+
+	if to == "" || to == latestRelease {
+		to = tags[0]
+	}
+	if from == latestRelease {
+		from = tags[0]
+	}
+	if from == "" {
+		for i, tag := range tags {
+			if tag == to && i+1 < len(tags) {
+				from = tags[i+1]
+				break
+			}
+		}
+		if from == "" {
+			return "", "", fmt.Errorf("no release found before %s", to)
+		}
+	}
+
+	return from, to, nil
+}
+
+// fetchCommitterDate returns the committer date of a ref's commit, or "" if it
+// can't be fetched
+func (p *Plugin) fetchCommitterDate(client *http.Client, repo, token, ref string) string {
+	commitURL := fmt.Sprintf("%s/repos/%s/commits/%s", githubAPIURL, repo, url.PathEscape(ref))
+	resp, err := client.Do(newGitHubRequest(commitURL, token))
+	if err != nil {
+		p.API.LogWarn("Failed to fetch commit", "repo", repo, "ref", ref, "error", err.Error())
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return ""
+	}
+
+	var commit GitHubCommit
+	if json.NewDecoder(resp.Body).Decode(&commit) != nil {
+		return ""
+	}
+	return commit.Commit.Committer.Date
+}
+
+// Merged PR search paging. The search API returns at most 1000 results.
+const (
+	prsPerPage     = 100
+	maxSearchPages = 10
+)
+
+// fetchMergedPRs returns pull requests merged into repo after from and up to to,
+// both RFC3339 dates, and whether some could not be listed. The merge that
+// produced the from tag belongs to the earlier release, so from is excluded.
+func (p *Plugin) fetchMergedPRs(client *http.Client, repo, token, from, to string) ([]MergedPR, bool) {
+	if fromTime, err := time.Parse(time.RFC3339, from); err == nil {
+		from = fromTime.Add(time.Second).UTC().Format(time.RFC3339)
+	}
+	query := fmt.Sprintf("repo:%s is:pr is:merged merged:%s..%s", repo, from, to)
+
+	prs := make([]MergedPR, 0)
+	for page := 1; page <= maxSearchPages; page++ {
+		searchURL := fmt.Sprintf("%s/search/issues?per_page=%d&page=%d&q=%s", githubAPIURL, prsPerPage, page, url.QueryEscape(query))
+		result, err := p.searchMergedPRs(client, searchURL, token)
+		if err != nil {
+			p.API.LogWarn("Failed to search merged PRs", "repo", repo, "page", page, "error", err.Error())
+			return prs, true
+		}
+		for _, item := range result.Items {
+			prs = append(prs, MergedPR{
+				Number:   item.Number,
+				Title:    item.Title,
+				Author:   item.User.Login,
+				URL:      item.HTMLURL,
+				MergedAt: item.PullRequest.MergedAt,
+			})
+		}
+		if len(result.Items) < prsPerPage || len(prs) >= result.TotalCount {
+			return prs, len(prs) < result.TotalCount
+		}
+	}
+	return prs, true
+}
+
+// mergedPRSearch is a page of merged PR search results
+type mergedPRSearch struct {
+	TotalCount int `json:"total_count"`
+	Items      []struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		PullRequest struct {
+			MergedAt string `json:"merged_at"`
+		} `json:"pull_request"`
+	} `json:"items"`
+}
+
+// searchMergedPRs fetches one page of merged PR search results
+func (p *Plugin) searchMergedPRs(client *http.Client, searchURL, token string) (*mergedPRSearch, error) {
+	resp, err := client.Do(newGitHubRequest(searchURL, token))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GitHub API error: %d", resp.StatusCode)
+	}
+
+	var result mergedPRSearch
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
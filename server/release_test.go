@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redirectTransport sends GitHub API requests to a test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newGitHubTestClient returns a client whose GitHub API requests are served by handler
+func newGitHubTestClient(t *testing.T, handler http.HandlerFunc) *http.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+func TestFetchMergedPRs(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	const total = 150
+	var queries []string
+	client := newGitHubTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/search/issues", r.URL.Path)
+		queries = append(queries, r.URL.Query().Get("q"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		items := make([]map[string]any, 0)
+		for n := (page-1)*prsPerPage + 1; n <= total && n <= page*prsPerPage; n++ {
+			items = append(items, map[string]any{"number": n, "title": fmt.Sprintf("PR %d", n)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"total_count": total, "items": items})
+	})
+
+	prs, truncated := p.fetchMergedPRs(client, "owner/repo", "token", "2025-03-01T10:00:00Z", "2025-03-08T10:00:00Z")
+	assert.False(t, truncated)
+	require.Len(t, prs, total)
+	assert.Equal(t, total, prs[total-1].Number)
+
+	// The PR merged at the from tag belongs to the previous release
+	require.Len(t, queries, 2)
+	assert.Equal(t, "repo:owner/repo is:pr is:merged merged:2025-03-01T10:00:01Z..2025-03-08T10:00:00Z", queries[0])
+}
+
+func TestFetchMergedPRsReportsFailedPages(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	p := &Plugin{}
+	p.SetAPI(api)
+	expectLogWarn(api, 3).Once()
+
+	client := newGitHubTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"total_count": 1, "items": [`))
+	})
+
+	prs, truncated := p.fetchMergedPRs(client, "owner/repo", "token", "2025-03-01T10:00:00Z", "2025-03-08T10:00:00Z")
+	assert.True(t, truncated)
+	assert.Empty(t, prs)
+}
+
+func TestFetchComparePages(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// GitHub lists at most 250 of the 300 commits, 100 per page
+	pages := 0
+	client := newGitHubTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/compare/v1.0.0...v2.0.0", r.URL.Path)
+		assert.Equal(t, strconv.Itoa(compareCommitsPerPage), r.URL.Query().Get("per_page"))
+		pages++
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		commits := make([]map[string]any, 0)
+		for n := (page-1)*compareCommitsPerPage + 1; n <= maxCompareCommits && n <= page*compareCommitsPerPage; n++ {
+			commits = append(commits, map[string]any{"sha": fmt.Sprint(n)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"total_commits": 300, "commits": commits})
+	})
+
+	compare, status, err := p.fetchCompare(client, "owner/repo", "token", "v1.0.0", "v2.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, 300, compare.TotalCommits)
+	require.Len(t, compare.Commits, maxCompareCommits)
+	assert.Equal(t, "250", compare.Commits[maxCompareCommits-1].SHA)
+}
+
+func TestFetchCompareUnknownTag(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(&plugintest.API{})
+	client := newGitHubTestClient(t, http.NotFound)
+
+	_, status, err := p.fetchCompare(client, "owner/repo", "token", "v1.0.0", "v9.9.9")
+	assert.EqualError(t, err, "tag not found")
+	assert.Equal(t, http.StatusNotFound, status)
+}
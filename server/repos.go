@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RepoWeekStats represents totals for a single week of a repository
+type RepoWeekStats struct {
+	Week         string `json:"week"`
+	Commits      int    `json:"commits"`
+	Added        int    `json:"added"`
+	Removed      int    `json:"removed"`
+	Contributors int    `json:"contributors"`
+}
+
+// RepoContributor represents a contributor's totals within a repository
+type RepoContributor struct {
+	Login        string         `json:"login"`
+	MMUserID     string         `json:"mm_user_id"`
+	MMUsername   string         `json:"mm_username"`
+	Name         string         `json:"name"`
+	Commits      int            `json:"commits"`
+	Added        int            `json:"added"`
+	Removed      int            `json:"removed"`
+	ChurnedLines int            `json:"churned_lines"`
+	ChurnRate    float64        `json:"churn_rate"`
+	ByType       map[string]int `json:"by_type"`
+	Breaking     int            `json:"breaking"`
+	Identities   []string       `json:"identities,omitempty"` // logins and commit emails merged into this row
+
+	owner      string // MM user ID, or the identity of unmapped contributors
+	identities map[string]bool
+}
+
+// RepoStatsResponse represents the per-repository summary response
+type RepoStatsResponse struct {
+	Repo               string            `json:"repo"`
+	Weeks              []RepoWeekStats   `json:"weeks"`
+	Commits            int               `json:"commits"`
+	Added              int               `json:"added"`
+	Removed            int               `json:"removed"`
+	ChurnedLines       int               `json:"churned_lines"`
+	ChurnRate          float64           `json:"churn_rate"`
+	ByType             map[string]int    `json:"by_type"`
+	Breaking           int               `json:"breaking"`
+	ActiveContributors int               `json:"active_contributors"`
+	TopContributors    []RepoContributor `json:"top_contributors"`
+	FirstActivity      string            `json:"first_activity"`
+	LastActivity       string            `json:"last_activity"`
+	WeekStart          string            `json:"week_start"`
+	WeekEnd            string            `json:"week_end"`
+	LastUpdated        string            `json:"last_updated"`
+	Warnings           []string          `json:"warnings,omitempty"`
+}
+
+const defaultTopContributors = 10
+
+// parseRepoPath extracts owner/repo and the trailing action from
+// /api/v1/repos/{owner}/{repo}/{action}
+func parseRepoPath(path string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v1/repos/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0] + "/" + parts[1], parts[2], true
+}
+
+// findTrackedRepo returns the configured spelling of repo, if it is tracked
+func findTrackedRepo(config *configuration, repo string) (string, bool) {
+	for _, tracked := range trackedRepos(config) {
+		if strings.EqualFold(tracked, repo) {
+			return tracked, true
+		}
+	}
+	return "", false
+}
+
+// handleRepoRoute dispatches /api/v1/repos/{owner}/{repo}/{action}
+func (p *Plugin) handleRepoRoute(w http.ResponseWriter, r *http.Request) {
+	repoName, action, ok := parseRepoPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch action {
+	case "stats":
+		p.handleGetRepoStats(w, r, repoName)
+	case "release":
+		p.handleGetReleaseReport(w, r, repoName)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGetRepoStats returns weekly totals, top contributors and activity
+// range for a single tracked repository
+func (p *Plugin) handleGetRepoStats(w http.ResponseWriter, r *http.Request, repoName string) {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	repo, tracked := findTrackedRepo(config, repoName)
+	if !tracked {
+		http.Error(w, `{"error": "repository is not tracked"}`, http.StatusNotFound)
+		return
+	}
+
+	top := defaultTopContributors
+	if v := r.URL.Query().Get("top"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			top = n
+		}
+	}
+
+	weekStart, weekEnd := weekRangeFromQuery(r)
+	currentWeekStr := currentISOWeek()
+
+	response := RepoStatsResponse{
+		Repo:      repo,
+		Weeks:     make([]RepoWeekStats, 0),
+		ByType:    make(map[string]int),
+		WeekStart: weekStart,
+		WeekEnd:   weekEnd,
+	}
+	// Keyed by MM user for mapped contributors, so their logins and emails merge
+	contributors := make(map[string]*RepoContributor)
+	mappings := p.getMappingIndex()
+	weeks := p.getWeeksInRange(weekStart, weekEnd)
+	hasSource := repoHasSource(config, repo)
+	if !hasSource {
+		response.Warnings = append(response.Warnings, noSourceWarning(repo))
+	}
+
+	for _, week := range weeks {
+		weekTotals := RepoWeekStats{Week: week}
+
+		weekStats := p.getWeeklyStats(repo, week, week == currentWeekStr, config.GitHubToken)
+		if weekStats == nil && hasSource {
+			response.Warnings = append(response.Warnings, fmt.Sprintf("%s %s: stats could not be fetched", repo, week))
+		} else if warning := weekStats.Warning(); warning != "" {
+			response.Warnings = append(response.Warnings, warning)
+		}
+		if weekStats != nil {
+			weekContributors := make(map[string]bool)
+			weekStartDate := weekToDate(week)
+			records := func() []CommitRecord { return p.loadCommitWeekRecords(repo, week) }
+			for _, owned := range mappings.ownStats(weekStats.Users, weekStartDate, weekStartDate.AddDate(0, 0, 7), records) {
+				owner, identity, stat := owned.Owner, owned.Identity, owned.Stat
+				if stat.Commits == 0 {
+					continue
+				}
+				weekTotals.Commits += stat.Commits
+				weekTotals.Added += stat.Added
+				weekTotals.Removed += stat.Removed
+				weekContributors[owner] = true
+
+				c := contributors[owner]
+				if c == nil {
+					c = &RepoContributor{owner: owner, identities: make(map[string]bool)}
+					contributors[owner] = c
+				}
+				c.identities[identity] = true
+				c.Commits += stat.Commits
+				c.Added += stat.Added
+				c.Removed += stat.Removed
+				c.ByType = addTypeCounts(c.ByType, stat.Types)
+				c.Breaking += stat.Breaking
+				response.ByType = addTypeCounts(response.ByType, stat.Types)
+				response.Breaking += stat.Breaking
+
+				// Older cached weeks don't carry commit dates
+				first, last := stat.FirstCommit, stat.LastCommit
+				if first == "" {
+					first = weekToDate(week).Format(time.RFC3339)
+				}
+				if last == "" {
+					last = weekToDate(week).AddDate(0, 0, 7).Add(-time.Second).Format(time.RFC3339)
+				}
+				if response.FirstActivity == "" || first < response.FirstActivity {
+					response.FirstActivity = first
+				}
+				if last > response.LastActivity {
+					response.LastActivity = last
+				}
+			}
+			weekTotals.Contributors = len(weekContributors)
+		}
+
+		response.Commits += weekTotals.Commits
+		response.Added += weekTotals.Added
+		response.Removed += weekTotals.Removed
+		response.Weeks = append(response.Weeks, weekTotals)
+	}
+
+	var repoChurn ChurnStat
+	contributorChurn := make(map[string]ChurnStat)
+	for owner, churn := range p.churnForRepo(repo, weeks, churnWindowDays(config), mappings) {
+		repoChurn.Added += churn.Added
+		repoChurn.Churned += churn.Churned
+		total := contributorChurn[owner]
+		total.Added += churn.Added
+		total.Churned += churn.Churned
+		contributorChurn[owner] = total
+	}
+	response.ChurnedLines = repoChurn.Churned
+	response.ChurnRate = repoChurn.Rate()
+
+	ranked := make([]*RepoContributor, 0, len(contributors))
+	for owner, c := range contributors {
+		c.Identities = sortedIdentities(c.identities)
+		c.Login = c.Identities[0]
+		c.ChurnedLines = contributorChurn[owner].Churned
+		c.ChurnRate = contributorChurn[owner].Rate()
+		ranked = append(ranked, c)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Commits != ranked[j].Commits {
+			return ranked[i].Commits > ranked[j].Commits
+		}
+		return ranked[i].Login < ranked[j].Login
+	})
+	if len(ranked) > top {
+		ranked = ranked[:top]
+	}
+
+	response.ActiveContributors = len(contributors)
+	response.TopContributors = make([]RepoContributor, 0, len(ranked))
+	for _, c := range ranked {
+		c.MMUserID, c.MMUsername, c.Name = p.resolveMMUser(c.owner, c.Login)
+		response.TopContributors = append(response.TopContributors, *c)
+	}
+	response.LastUpdated = time.Now().Format(time.RFC3339)
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// weeklyStatsSchemaVersion is the version of WeeklyRepoStats written by this build.
+// Bump it whenever a field is added that older entries can't be assumed to carry.
+// Entries written before versioning have no version (0) and are outdated.
+//
+//	1: schema version
+//	2: completeness status
+//	3: commits without a GitHub account attributed to their author email
+const weeklyStatsSchemaVersion = 3
+
+// Completeness status of a fetched week
+const (
+	weekStatusComplete  = "complete"
+	weekStatusTruncated = "truncated" // more commits than pages fetched, or detail fetch limit hit
+	weekStatusErrored   = "errored"   // a commits page or some commit details failed to fetch
+	weekStatusEmpty     = "empty"
+)
+
+// Past weeks that aren't complete are cached with an expiry. Errored weeks are
+// retried soon; a truncated week would be cut off the same way when refetched,
+// so it is only refetched in case the limits changed.
+const (
+	emptyWeekTTL     = 7 * 24 * time.Hour // negative cache for weeks without commits
+	partialWeekTTL   = 6 * time.Hour
+	truncatedWeekTTL = 30 * 24 * time.Hour
+)
+
+// fetchRetryDelay is how long a past week whose fetch failed is served from
+// whatever is cached before it is fetched again
+const fetchRetryDelay = time.Minute
+
+// Commits list paging limits per week
+const (
+	commitsPerPage = 100
+	maxCommitPages = 5
+)
+
+// maxMigrationsPerSync limits how many outdated weeks each sync run refetches
+const maxMigrationsPerSync = 20
+
+// migrationRetryDelay is how long an outdated week whose refetch failed is left
+// alone, so weeks that keep failing don't hold up the others
+const migrationRetryDelay = 24 * time.Hour
+
+// migrationLockKey is the cluster mutex held while outdated weeks are migrated
+const migrationLockKey = "cache_migration_lock"
+
+// migratedSchemaKey holds the schema version all cached weeks were found on by
+// a full migration pass, so the sync job stops scanning the KV store until the
+// version changes
+const migratedSchemaKey = "cache_migrated_schema"
+
+// weekStatsTTL returns how long a past week with the given status stays cached,
+// 0 meaning permanently. Entries from before completeness tracking have no status.
+func weekStatsTTL(status string) time.Duration {
+	switch status {
+	case weekStatusComplete, "":
+		return 0
+	case weekStatusEmpty:
+		return emptyWeekTTL
+	case weekStatusTruncated:
+		return truncatedWeekTTL
+	default:
+		return partialWeekTTL
+	}
+}
+
+// Outdated reports whether the entry was written by an older schema and is missing data
+func (s *WeeklyRepoStats) Outdated() bool {
+	return s.SchemaVersion < weeklyStatsSchemaVersion
+}
+
+// Warning describes missing data in a partial week, or "" if the week is complete.
+// Entries written before the reasons were stored only carry MissingDetails.
+func (s *WeeklyRepoStats) Warning() string {
+	if s.Status != weekStatusTruncated && s.Status != weekStatusErrored {
+		return ""
+	}
+
+	skipped, failed := s.MissingDetails-s.FailedDetails, s.FailedDetails
+	if s.Status == weekStatusErrored && failed == 0 && !s.PageFailed {
+		skipped, failed = 0, s.MissingDetails
+	}
+	var reasons []string
+	if s.PageLimit {
+		reasons = append(reasons, fmt.Sprintf("more than %d commits, only the first %d were counted", commitsPerPage*maxCommitPages, commitsPerPage*maxCommitPages))
+	}
+	if s.PageFailed {
+		reasons = append(reasons, "some commits could not be listed (GitHub errors)")
+	}
+	if skipped > 0 {
+		reasons = append(reasons, fmt.Sprintf("line counts missing for %d commits (fetch limit reached)", skipped))
+	}
+	if failed > 0 {
+		reasons = append(reasons, fmt.Sprintf("line counts missing for %d commits (GitHub errors)", failed))
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "some commits were not counted")
+	}
+	return fmt.Sprintf("%s %s: %s", s.Repo, s.Week, strings.Join(reasons, "; "))
+}
+
+// isCachedUpToDate reports whether a repo+week is cached with the current schema
+func (p *Plugin) isCachedUpToDate(repo, week string) bool {
+	cached := p.loadCachedWeeklyStats(repo, week)
+	return cached != nil && !cached.Outdated()
+}
+
+// migrateOutdatedWeeks refetches up to limit cached weeks written by an older
+// schema (limit <= 0 means all). It returns how many were upgraded, or 0 if a
+// migration is running already or an earlier pass found nothing left to do.
+func (p *Plugin) migrateOutdatedWeeks(limit int) int {
+	if data, appErr := p.API.KVGet(migratedSchemaKey); appErr == nil && string(data) == fmt.Sprint(weeklyStatsSchemaVersion) {
+		return 0
+	}
+	mutex := p.lockMigration()
+	if mutex == nil {
+		return 0
+	}
+	defer mutex.Unlock()
+	return p.migrateOutdatedWeeksLocked(limit)
+}
+
+// startMigration upgrades all outdated cached weeks in the background
+func (p *Plugin) startMigration() error {
+	mutex := p.lockMigration()
+	if mutex == nil {
+		return fmt.Errorf("a migration is already running")
+	}
+	go func() {
+		defer mutex.Unlock()
+		p.migrateOutdatedWeeksLocked(0)
+	}()
+	return nil
+}
+
+// lockMigration takes the migration lock, returning nil if another run on
+// any node holds it
+func (p *Plugin) lockMigration() *cluster.Mutex {
+	mutex, err := cluster.NewMutex(p.API, migrationLockKey)
+	if err != nil {
+		p.API.LogWarn("Failed to create migration lock", "error", err.Error())
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if mutex.LockWithContext(ctx) != nil {
+		return nil
+	}
+	return mutex
+}
+
+// migrateOutdatedWeeksLocked does the migration under the migration lock.
+// Untracked repos and those that can't be fetched are skipped, as are weeks
+// whose refetch failed recently. Skipped weeks are upgraded lazily when read.
+// Once a full pass finds no outdated week but skipped ones of untracked or
+// unfetchable repos, the schema version is recorded as migrated.
+func (p *Plugin) migrateOutdatedWeeksLocked(limit int) int {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		return 0
+	}
+	token := config.GitHubToken
+
+	var outdated []*WeeklyRepoStats
+	backedOff := 0
+	err := p.scanCachedWeeks(func(key string, stats *WeeklyRepoStats) bool {
+		if !stats.Outdated() || stats.Repo == "" || stats.Week == "" {
+			return true
+		}
+		if _, tracked := findTrackedRepo(config, stats.Repo); !tracked || !repoHasSource(config, stats.Repo) {
+			return true
+		}
+		if p.failedWithin(stats.Repo, stats.Week, migrationRetryDelay) {
+			backedOff++
+			return true
+		}
+		outdated = append(outdated, stats)
+		return limit <= 0 || len(outdated) < limit
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to scan cache for outdated weeks", "error", err.Error())
+		return 0
+	}
+	if len(outdated) == 0 && backedOff == 0 {
+		if appErr := p.API.KVSet(migratedSchemaKey, []byte(fmt.Sprint(weeklyStatsSchemaVersion))); appErr != nil {
+			p.API.LogWarn("Failed to save migrated schema version", "error", appErr.Error())
+		}
+		return 0
+	}
+
+	migrated := 0
+	for _, stats := range outdated {
+		// Weeks with stored commits can be rebuilt without calling GitHub
+		if rebuilt := p.rebuildWeeklyStats(stats.Repo, stats.Week); rebuilt != nil {
+			p.cacheWeeklyStats(rebuilt)
+			migrated++
+			continue
+		}
+		if p.refreshWeeklyStats(stats.Repo, stats.Week, false, token) == nil {
+			// Failed fetches are recorded, which backs the week off
+			p.API.LogWarn("Failed to migrate cached week", "repo", stats.Repo, "week", stats.Week)
+			continue
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		p.API.LogInfo("Migrated outdated cached weeks", "count", migrated, "schema_version", weeklyStatsSchemaVersion)
+	}
+	return migrated
+}
+
+// handleMigrateCache upgrades all outdated cached weeks in the background
+func (p *Plugin) handleMigrateCache(w http.ResponseWriter, r *http.Request) {
+	if !hasStatsSource(p.getConfiguration()) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := p.startMigration(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "started",
+		"schema_version": weeklyStatsSchemaVersion,
+	})
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	mappingsKey        = "gh_mappings"
+	mappingsLockKey    = "gh_mappings_lock"
+	fetchMetaKeyPrefix = "gh_fetch"
+)
+
+// mappingsLockTimeout bounds how long a mapping update waits for another node's
+const mappingsLockTimeout = 30 * time.Second
+
+// FetchMeta records a failed fetch of a repo+week, so it isn't retried right
+// away. Successful fetches only update it to clear a failure; otherwise the
+// FetchedAt of the stored stats tells when a week was fetched.
+type FetchMeta struct {
+	Repo          string `json:"repo"`
+	Week          string `json:"week"`
+	Source        string `json:"source"` // github or local
+	LastAttemptAt string `json:"last_attempt_at"`
+	LastSuccessAt string `json:"last_success_at,omitempty"`
+	Failed        bool   `json:"failed"` // the last attempt returned no data
+}
+
+// StatsStore persists weekly stats, user mappings and fetch metadata.
+// Getters return nil without an error for missing entries. Patches, commit
+// records and their indexes, and the backfill status are not part of it and
+// always live in the plugin KV store.
+type StatsStore interface {
+	// Cached weekly stats of past weeks. A zero ttl keeps the entry permanently.
+	GetWeeklyStats(repo, week string) (*WeeklyRepoStats, error)
+	SetWeeklyStats(stats *WeeklyRepoStats, ttl time.Duration) error
+	DeleteWeeklyStats(repo, week string) error
+	// ScanWeeklyStats calls fn for every cached entry until fn returns false
+	ScanWeeklyStats(fn func(key string, stats *WeeklyRepoStats) bool) error
+
+	// Current-week snapshots kept by the sync job. They expire after currentWeekTTL.
+	GetCurrentWeek(repo, week string) (*WeeklyRepoStats, error)
+	SetCurrentWeek(stats *WeeklyRepoStats) error
+	DeleteCurrentWeek(repo, week string) error
+
+	// GetMappings returns nil when no mapping set was ever stored
+	GetMappings() (*MappingSet, error)
+	SetMappings(set *MappingSet) error
+	// LockMappings serializes read-modify-write of the mappings, across the
+	// cluster where the store is shared, until unlock is called
+	LockMappings() (unlock func(), err error)
+
+	GetFetchMeta(repo, week string) (*FetchMeta, error)
+	SetFetchMeta(meta *FetchMeta) error
+
+	// Mapping audit log, bucketed per UTC day (YYYY-MM-DD) and kept permanently.
+	// Entries of one append share a timestamp.
+	AppendMappingAudit(entries []MappingAuditEntry) error
+	GetMappingAudit(day string) ([]MappingAuditEntry, error)
+}
+
+// getStore returns the plugin's stats store, the KV store unless one was injected
+func (p *Plugin) getStore() StatsStore {
+	p.storeLock.Lock()
+	defer p.storeLock.Unlock()
+
+	if p.store == nil {
+		p.store = newKVStore(p.API)
+	}
+	return p.store
+}
+
+// kvStore keeps everything in the plugin KV store
+type kvStore struct {
+	api plugin.API
+}
+
+func newKVStore(api plugin.API) *kvStore {
+	return &kvStore{api: api}
+}
+
+// getJSON loads a JSON value, returning false if the key is missing
+func (s *kvStore) getJSON(key string, v interface{}) (bool, error) {
+	data, appErr := s.api.KVGet(key)
+	if appErr != nil {
+		return false, appErr
+	}
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *kvStore) setJSON(key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		if appErr := s.api.KVSetWithExpiry(key, data, int64(ttl/time.Second)); appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+	if appErr := s.api.KVSet(key, data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// setRepoJSON stores a value of a repo and adds its key to the repo's key index
+func (s *kvStore) setRepoJSON(repo, key string, v interface{}, ttl time.Duration) error {
+	if err := s.setJSON(key, v, ttl); err != nil {
+		return err
+	}
+	return indexRepoKey(s.api, repo, key)
+}
+
+func (s *kvStore) delete(key string) error {
+	if appErr := s.api.KVDelete(key); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (s *kvStore) getStats(key string) (*WeeklyRepoStats, error) {
+	var stats WeeklyRepoStats
+	found, err := s.getJSON(key, &stats)
+	if !found {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (s *kvStore) GetWeeklyStats(repo, week string) (*WeeklyRepoStats, error) {
+	return s.getStats(repoWeekKey("gh_stats", repo, week))
+}
+
+func (s *kvStore) SetWeeklyStats(stats *WeeklyRepoStats, ttl time.Duration) error {
+	return s.setRepoJSON(stats.Repo, repoWeekKey("gh_stats", stats.Repo, stats.Week), stats, ttl)
+}
+
+func (s *kvStore) DeleteWeeklyStats(repo, week string) error {
+	return s.delete(repoWeekKey("gh_stats", repo, week))
+}
+
+func (s *kvStore) ScanWeeklyStats(fn func(key string, stats *WeeklyRepoStats) bool) error {
+	for page := 0; ; page++ {
+		keys, appErr := s.api.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return appErr
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, statsKeyPrefix) {
+				continue
+			}
+			stats, err := s.getStats(key)
+			if err != nil || stats == nil {
+				continue
+			}
+			if !fn(key, stats) {
+				return nil
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return nil
+		}
+	}
+}
+
+func (s *kvStore) GetCurrentWeek(repo, week string) (*WeeklyRepoStats, error) {
+	return s.getStats(repoWeekKey("gh_current", repo, week))
+}
+
+func (s *kvStore) SetCurrentWeek(stats *WeeklyRepoStats) error {
+	return s.setRepoJSON(stats.Repo, repoWeekKey("gh_current", stats.Repo, stats.Week), stats, currentWeekTTL)
+}
+
+func (s *kvStore) DeleteCurrentWeek(repo, week string) error {
+	return s.delete(repoWeekKey("gh_current", repo, week))
+}
+
+func (s *kvStore) GetMappings() (*MappingSet, error) {
+	var set MappingSet
+	found, err := s.getJSON(mappingsKey, &set)
+	if !found {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (s *kvStore) SetMappings(set *MappingSet) error {
+	return s.setJSON(mappingsKey, set, 0)
+}
+
+func (s *kvStore) LockMappings() (func(), error) {
+	mutex, err := cluster.NewMutex(s.api, mappingsLockKey)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mappingsLockTimeout)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("timed out waiting for the mappings lock: %w", err)
+	}
+	return mutex.Unlock, nil
+}
+
+func (s *kvStore) GetFetchMeta(repo, week string) (*FetchMeta, error) {
+	var meta FetchMeta
+	found, err := s.getJSON(repoWeekKey(fetchMetaKeyPrefix, repo, week), &meta)
+	if !found {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (s *kvStore) SetFetchMeta(meta *FetchMeta) error {
+	return s.setRepoJSON(meta.Repo, repoWeekKey(fetchMetaKeyPrefix, meta.Repo, meta.Week), meta, 0)
+}
+
+func (s *kvStore) AppendMappingAudit(entries []MappingAuditEntry) error {
+	at, err := time.Parse(time.RFC3339Nano, entries[0].At)
+	if err != nil {
+		return err
+	}
+	key := mappingAuditKeyPrefix + ":" + auditDay(at)
+
+	// Nodes may append concurrently, so the bucket is updated with compare-and-set
+	for i := 0; i < maxKeyIndexRetries; i++ {
+		old, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return appErr
+		}
+		var bucket []MappingAuditEntry
+		if old != nil {
+			if err := json.Unmarshal(old, &bucket); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(append(bucket, entries...))
+		if err != nil {
+			return err
+		}
+		ok, appErr := s.api.KVCompareAndSet(key, old, data)
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("mapping audit log %s changed concurrently", key)
+}
+
+func (s *kvStore) GetMappingAudit(day string) ([]MappingAuditEntry, error) {
+	var entries []MappingAuditEntry
+	if _, err := s.getJSON(mappingAuditKeyPrefix+":"+day, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// memoryStore keeps everything in process memory. It only backs tests; the
+// plugin always runs on the KV store.
+type memoryStore struct {
+	lock         sync.Mutex
+	mappingsLock sync.Mutex
+	stats        map[string]memoryEntry
+	current      map[string]memoryEntry
+	mappings     *MappingSet
+	fetchMeta    map[string]FetchMeta
+	audit        map[string][]MappingAuditEntry
+}
+
+type memoryEntry struct {
+	stats     WeeklyRepoStats
+	expiresAt time.Time // zero for permanent entries
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		stats:     make(map[string]memoryEntry),
+		current:   make(map[string]memoryEntry),
+		fetchMeta: make(map[string]FetchMeta),
+		audit:     make(map[string][]MappingAuditEntry),
+	}
+}
+
+func (s *memoryStore) GetWeeklyStats(repo, week string) (*WeeklyRepoStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := repoWeekKey("gh_stats", repo, week)
+	entry, ok := s.stats[key]
+	if !ok {
+		return nil, nil
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(s.stats, key)
+		return nil, nil
+	}
+	stats := entry.stats
+	return &stats, nil
+}
+
+func (s *memoryStore) SetWeeklyStats(stats *WeeklyRepoStats, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry := memoryEntry{stats: *stats}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	s.stats[repoWeekKey("gh_stats", stats.Repo, stats.Week)] = entry
+	return nil
+}
+
+func (s *memoryStore) DeleteWeeklyStats(repo, week string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.stats, repoWeekKey("gh_stats", repo, week))
+	return nil
+}
+
+func (s *memoryStore) ScanWeeklyStats(fn func(key string, stats *WeeklyRepoStats) bool) error {
+	// Copy out of the lock so fn may call back into the store
+	s.lock.Lock()
+	keys := make([]string, 0, len(s.stats))
+	for key := range s.stats {
+		keys = append(keys, key)
+	}
+	s.lock.Unlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s.lock.Lock()
+		entry, ok := s.stats[key]
+		s.lock.Unlock()
+		if !ok || (!entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)) {
+			continue
+		}
+		stats := entry.stats
+		if !fn(key, &stats) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetCurrentWeek(repo, week string) (*WeeklyRepoStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.current[repoWeekKey("gh_current", repo, week)]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return &entry.stats, nil
+}
+
+func (s *memoryStore) SetCurrentWeek(stats *WeeklyRepoStats) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.current[repoWeekKey("gh_current", stats.Repo, stats.Week)] = memoryEntry{stats: *stats, expiresAt: time.Now().Add(currentWeekTTL)}
+	return nil
+}
+
+func (s *memoryStore) DeleteCurrentWeek(repo, week string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.current, repoWeekKey("gh_current", repo, week))
+	return nil
+}
+
+func (s *memoryStore) GetMappings() (*MappingSet, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.mappings == nil {
+		return nil, nil
+	}
+	return copyMappingSet(s.mappings), nil
+}
+
+func (s *memoryStore) SetMappings(set *MappingSet) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.mappings = copyMappingSet(set)
+	return nil
+}
+
+func (s *memoryStore) LockMappings() (func(), error) {
+	s.mappingsLock.Lock()
+	return s.mappingsLock.Unlock, nil
+}
+
+func copyMappingSet(set *MappingSet) *MappingSet {
+	return &MappingSet{
+		Version:    set.Version,
+		Revision:   set.Revision,
+		Mappings:   append([]UserMapping{}, set.Mappings...),
+		Unresolved: append([]UnresolvedMapping(nil), set.Unresolved...),
+	}
+}
+
+func (s *memoryStore) GetFetchMeta(repo, week string) (*FetchMeta, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	meta, ok := s.fetchMeta[repoWeekKey(fetchMetaKeyPrefix, repo, week)]
+	if !ok {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+func (s *memoryStore) SetFetchMeta(meta *FetchMeta) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.fetchMeta[repoWeekKey(fetchMetaKeyPrefix, meta.Repo, meta.Week)] = *meta
+	return nil
+}
+
+func (s *memoryStore) AppendMappingAudit(entries []MappingAuditEntry) error {
+	at, err := time.Parse(time.RFC3339Nano, entries[0].At)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	day := auditDay(at)
+	s.audit[day] = append(s.audit[day], entries...)
+	return nil
+}
+
+func (s *memoryStore) GetMappingAudit(day string) ([]MappingAuditEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]MappingAuditEntry(nil), s.audit[day]...), nil
+}
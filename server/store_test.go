@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRepo     = "acme/app"
+	testPastWeek = "2025-W10"
+)
+
+// newTestPlugin returns a plugin backed by an in-memory store whose only stats
+// source is a local clone at repoPath, so fetches never reach GitHub
+func newTestPlugin(t *testing.T, repoPath string) (*Plugin, *plugintest.API, *memoryStore) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	// Fetch locks are cluster mutexes in the KV store
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(true, nil).Maybe()
+
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{
+		Repositories:   testRepo,
+		LocalRepoPaths: `{"` + testRepo + `": "` + repoPath + `"}`,
+	})
+	return p, api, store
+}
+
+// expectCommitStorage allows the commit record and patch writes of a fetch
+func expectCommitStorage(api *plugintest.API) {
+	api.On("KVGet", mock.Anything).Return(nil, nil).Maybe()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil).Maybe()
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(true, nil).Maybe()
+}
+
+// expectLogWarn allows a warning with the given number of key-value pairs
+func expectLogWarn(api *plugintest.API, pairs int) *mock.Call {
+	args := make([]interface{}, 1+2*pairs)
+	for i := range args {
+		args[i] = mock.Anything
+	}
+	return api.On("LogWarn", args...).Return()
+}
+
+// initTestRepo creates a git repository with one commit per date, each adding lines to a file
+func initTestRepo(t *testing.T, login string, dates ...time.Time) string {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	content := ""
+	for i, date := range dates {
+		content += "line added by commit " + string(rune('a'+i)) + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(content), 0o644))
+		_, err = wt.Add("main.go")
+		require.NoError(t, err)
+
+		sig := &object.Signature{Name: login, Email: "1+" + login + "@users.noreply.github.com", When: date}
+		_, err = wt.Commit("feat: change "+string(rune('a'+i)), &git.CommitOptions{Author: sig, Committer: sig})
+		require.NoError(t, err)
+	}
+	return dir
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := newMemoryStore()
+
+	stats, err := store.GetWeeklyStats(testRepo, testPastWeek)
+	require.NoError(t, err)
+	assert.Nil(t, stats)
+
+	require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{Repo: testRepo, Week: testPastWeek, Status: weekStatusComplete}, 0))
+	stats, err = store.GetWeeklyStats(testRepo, testPastWeek)
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, weekStatusComplete, stats.Status)
+
+	t.Run("expired entries are missing", func(t *testing.T) {
+		require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{Repo: testRepo, Week: "2025-W11"}, time.Millisecond))
+		time.Sleep(5 * time.Millisecond)
+		stats, err := store.GetWeeklyStats(testRepo, "2025-W11")
+		require.NoError(t, err)
+		assert.Nil(t, stats)
+	})
+
+	t.Run("scan and delete", func(t *testing.T) {
+		var weeks []string
+		require.NoError(t, store.ScanWeeklyStats(func(key string, stats *WeeklyRepoStats) bool {
+			weeks = append(weeks, stats.Week)
+			return true
+		}))
+		assert.Equal(t, []string{testPastWeek}, weeks)
+
+		require.NoError(t, store.DeleteWeeklyStats(testRepo, testPastWeek))
+		stats, err := store.GetWeeklyStats(testRepo, testPastWeek)
+		require.NoError(t, err)
+		assert.Nil(t, stats)
+	})
+
+	t.Run("mappings are copied", func(t *testing.T) {
+		set := &MappingSet{Mappings: []UserMapping{{GitHubLogin: "octocat", MMUserID: "user1"}}}
+		require.NoError(t, store.SetMappings(set))
+		set.Mappings[0].MMUserID = "user2"
+
+		stored, err := store.GetMappings()
+		require.NoError(t, err)
+		assert.Equal(t, "user1", stored.Mappings[0].MMUserID)
+	})
+}
+
+func TestCurrentWeekSnapshotsExpire(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	key := repoWeekKey("gh_current", testRepo, testPastWeek)
+	api.On("KVSetWithExpiry", key, mock.Anything, int64(currentWeekTTL/time.Second)).Return(nil).Once()
+	newFakeKV(api)
+
+	stats := &WeeklyRepoStats{Repo: testRepo, Week: testPastWeek}
+	require.NoError(t, newKVStore(api).SetCurrentWeek(stats))
+}
+
+func TestGetWeeklyStatsPastWeek(t *testing.T) {
+	weekStart := weekToDate(testPastWeek)
+
+	t.Run("cache hit does not fetch", func(t *testing.T) {
+		// No LogWarn or KV expectations: any fetch attempt fails the test
+		p, _, store := newTestPlugin(t, filepath.Join(t.TempDir(), "missing"))
+		cached := &WeeklyRepoStats{
+			SchemaVersion: weeklyStatsSchemaVersion,
+			Repo:          testRepo,
+			Week:          testPastWeek,
+			Users:         map[string]WeekUserStat{"octocat": {Commits: 3}},
+			Status:        weekStatusComplete,
+		}
+		require.NoError(t, store.SetWeeklyStats(cached, 0))
+
+		stats := p.getWeeklyStats(testRepo, testPastWeek, false, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, 3, stats.Users["octocat"].Commits)
+	})
+
+	t.Run("cache miss fetches and caches", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", weekStart.Add(24*time.Hour), weekStart.Add(48*time.Hour))
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+
+		stats := p.getWeeklyStats(testRepo, testPastWeek, false, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, weekStatusComplete, stats.Status)
+		assert.Equal(t, 2, stats.Users["octocat"].Commits)
+		assert.Equal(t, 2, stats.Users["octocat"].Added)
+		assert.Equal(t, 2, stats.Users["octocat"].Types["feat"])
+
+		cached, err := store.GetWeeklyStats(testRepo, testPastWeek)
+		require.NoError(t, err)
+		require.NotNil(t, cached)
+		assert.Equal(t, stats.Users, cached.Users)
+
+		// Only failures are recorded
+		meta, err := store.GetFetchMeta(testRepo, testPastWeek)
+		require.NoError(t, err)
+		assert.Nil(t, meta)
+	})
+
+	t.Run("outdated entry is refetched", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", weekStart.Add(24*time.Hour))
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+		require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{
+			Repo:  testRepo,
+			Week:  testPastWeek,
+			Users: map[string]WeekUserStat{"octocat": {Commits: 9}},
+		}, 0))
+
+		stats := p.getWeeklyStats(testRepo, testPastWeek, false, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, weeklyStatsSchemaVersion, stats.SchemaVersion)
+		assert.Equal(t, 1, stats.Users["octocat"].Commits)
+	})
+
+	t.Run("success clears a recorded failure", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", weekStart.Add(24*time.Hour))
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+		require.NoError(t, store.SetFetchMeta(&FetchMeta{Repo: testRepo, Week: testPastWeek, LastAttemptAt: time.Now().Add(-time.Hour).Format(time.RFC3339), Failed: true}))
+
+		require.NotNil(t, p.refreshWeeklyStats(testRepo, testPastWeek, false, ""))
+
+		meta, err := store.GetFetchMeta(testRepo, testPastWeek)
+		require.NoError(t, err)
+		require.NotNil(t, meta)
+		assert.False(t, meta.Failed)
+		assert.Equal(t, "local", meta.Source)
+	})
+
+	t.Run("failed fetch is not retried immediately", func(t *testing.T) {
+		p, api, store := newTestPlugin(t, filepath.Join(t.TempDir(), "missing"))
+		expectLogWarn(api, 3).Once()
+
+		assert.Nil(t, p.getWeeklyStats(testRepo, testPastWeek, false, ""))
+		assert.Nil(t, p.getWeeklyStats(testRepo, testPastWeek, false, ""))
+
+		meta, err := store.GetFetchMeta(testRepo, testPastWeek)
+		require.NoError(t, err)
+		require.NotNil(t, meta)
+		assert.True(t, meta.Failed)
+	})
+
+	t.Run("empty week gets a negative cache entry", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", weekStart.AddDate(0, 0, -14))
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+
+		stats := p.getWeeklyStats(testRepo, testPastWeek, false, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, weekStatusEmpty, stats.Status)
+
+		cached, err := store.GetWeeklyStats(testRepo, testPastWeek)
+		require.NoError(t, err)
+		require.NotNil(t, cached)
+		assert.False(t, store.stats[repoWeekKey("gh_stats", testRepo, testPastWeek)].expiresAt.IsZero())
+	})
+}
+
+func TestGetWeeklyStatsCurrentWeek(t *testing.T) {
+	week := currentISOWeek()
+
+	t.Run("current week is only stored as a snapshot", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", time.Now())
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+
+		stats := p.getWeeklyStats(testRepo, week, true, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, 1, stats.Users["octocat"].Commits)
+
+		cached, err := store.GetWeeklyStats(testRepo, week)
+		require.NoError(t, err)
+		assert.Nil(t, cached)
+
+		snapshot, err := store.GetCurrentWeek(testRepo, week)
+		require.NoError(t, err)
+		require.NotNil(t, snapshot)
+		assert.Equal(t, 1, snapshot.Users["octocat"].Commits)
+	})
+
+	t.Run("fresh snapshot is served without fetching", func(t *testing.T) {
+		p, _, store := newTestPlugin(t, filepath.Join(t.TempDir(), "missing"))
+
+		require.NoError(t, store.SetCurrentWeek(&WeeklyRepoStats{
+			SchemaVersion: weeklyStatsSchemaVersion,
+			Repo:          testRepo,
+			Week:          week,
+			Users:         map[string]WeekUserStat{"octocat": {Commits: 5}},
+			FetchedAt:     time.Now().Format(time.RFC3339),
+			Status:        weekStatusComplete,
+		}))
+
+		stats := p.getWeeklyStats(testRepo, week, true, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, 5, stats.Users["octocat"].Commits)
+	})
+
+	t.Run("stale snapshot is served and revalidated", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", time.Now())
+		p, api, store := newTestPlugin(t, dir)
+		expectCommitStorage(api)
+
+		published := make(chan map[string]interface{}, 1)
+		api.On("PublishWebSocketEvent", statsUpdatedEvent, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			published <- args.Get(1).(map[string]interface{})
+		}).Return().Once()
+
+		require.NoError(t, store.SetCurrentWeek(&WeeklyRepoStats{
+			SchemaVersion: weeklyStatsSchemaVersion,
+			Repo:          testRepo,
+			Week:          week,
+			Users:         map[string]WeekUserStat{"octocat": {Commits: 5}},
+			FetchedAt:     time.Now().Add(-time.Hour).Format(time.RFC3339),
+		}))
+
+		stats := p.getWeeklyStats(testRepo, week, true, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, 5, stats.Users["octocat"].Commits)
+
+		select {
+		case event := <-published:
+			assert.Equal(t, testRepo, event["repo"])
+			assert.Equal(t, week, event["week"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("no stats_updated event published")
+		}
+
+		require.Eventually(t, func() bool { return !p.isRevalidating(testRepo, week) }, 5*time.Second, 10*time.Millisecond)
+		snapshot, err := store.GetCurrentWeek(testRepo, week)
+		require.NoError(t, err)
+		require.NotNil(t, snapshot)
+		assert.Equal(t, 1, snapshot.Users["octocat"].Commits)
+	})
+}
+
+func TestRefreshWeeklyStatsCoalesces(t *testing.T) {
+	weekStart := weekToDate(testPastWeek)
+
+	t.Run("concurrent callers share one fetch", func(t *testing.T) {
+		dir := initTestRepo(t, "octocat", weekStart.Add(24*time.Hour))
+		p, api, _ := newTestPlugin(t, dir)
+
+		// Every fetch writes the repo-week commit index exactly once
+		fetches := 0
+		var fetchesLock sync.Mutex
+		api.On("KVSet", repoWeekKey("gh_cidx_week", testRepo, testPastWeek), mock.Anything).Run(func(mock.Arguments) {
+			fetchesLock.Lock()
+			fetches++
+			fetchesLock.Unlock()
+		}).Return(nil)
+		expectCommitStorage(api)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stats := p.getWeeklyStats(testRepo, testPastWeek, false, "")
+				assert.NotNil(t, stats)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, fetches)
+	})
+
+	t.Run("week fetched while waiting is not fetched again", func(t *testing.T) {
+		// The clone is missing, so a fetch would log a warning the mock doesn't expect
+		p, _, store := newTestPlugin(t, filepath.Join(t.TempDir(), "missing"))
+		require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{
+			SchemaVersion: weeklyStatsSchemaVersion,
+			Repo:          testRepo,
+			Week:          testPastWeek,
+			Users:         map[string]WeekUserStat{"octocat": {Commits: 4}},
+			Status:        weekStatusComplete,
+			FetchedAt:     time.Now().Add(2 * time.Second).Format(time.RFC3339),
+		}, 0))
+
+		stats := p.refreshWeeklyStats(testRepo, testPastWeek, false, "")
+		require.NotNil(t, stats)
+		assert.Equal(t, 4, stats.Users["octocat"].Commits)
+	})
+}
+
+func TestReposWithoutSourceAreSkipped(t *testing.T) {
+	dir := initTestRepo(t, "octocat", weekToDate(testPastWeek).Add(24*time.Hour))
+	p, api, store := newTestPlugin(t, dir)
+	expectCommitStorage(api)
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion}))
+	config := p.getConfiguration()
+	config.Repositories = testRepo + ", acme/remote"
+	p.setConfiguration(config)
+
+	// Without a token the cloneless repo is never fetched from GitHub, which
+	// would log warnings the mock doesn't expect
+	assert.Equal(t, []string{"acme/remote"}, reposWithoutSource(config))
+	assert.Nil(t, p.getWeeklyStats("acme/remote", testPastWeek, false, ""))
+
+	stats := p.collectStats(config, testPastWeek, testPastWeek)
+	assert.Equal(t, []string{noSourceWarning("acme/remote")}, stats.Warnings)
+	require.Len(t, stats.Users, 1)
+	assert.Equal(t, 1, stats.Users[0].Commits)
+}
+
+func TestStartBackfill(t *testing.T) {
+	dir := initTestRepo(t, "octocat", weekToDate(testPastWeek).Add(24*time.Hour))
+	p, api, _ := newTestPlugin(t, dir)
+
+	// Hold the backfill at its first progress update until the checks are done
+	var kv *fakeKV
+	saves := 0
+	release := make(chan struct{})
+	api.On("KVSet", backfillStatusKey, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		if saves++; saves == 2 {
+			<-release
+		}
+		kv.set(key, value)
+		return nil
+	})
+	kv = newFakeKV(api)
+	finished := make(chan struct{})
+	api.On("LogInfo", "Cache backfill finished", "total", 1, "skipped", 0, "failed", 0).Run(func(mock.Arguments) {
+		close(finished)
+	}).Return().Once()
+
+	status, err := p.startBackfill([]string{testRepo}, []string{testPastWeek}, false, "admin")
+	require.NoError(t, err)
+
+	// The returned status is a snapshot, not the one the backfill updates
+	assert.True(t, status.Running)
+	assert.Equal(t, 0, status.Done)
+	_, err = p.startBackfill([]string{testRepo}, []string{testPastWeek}, false, "admin")
+	assert.EqualError(t, err, "a backfill is already running")
+	close(release)
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("backfill didn't finish")
+	}
+	stored := p.getBackfillStatus()
+	require.NotNil(t, stored)
+	assert.False(t, stored.Running)
+	assert.Equal(t, 1, stored.Done)
+	assert.Equal(t, 0, status.Done)
+}
+
+func TestWeeklyStatsWarning(t *testing.T) {
+	week := func(status string, missing, failed int, pageLimit, pageFailed bool) *WeeklyRepoStats {
+		return &WeeklyRepoStats{Repo: testRepo, Week: testPastWeek, Status: status, MissingDetails: missing, FailedDetails: failed, PageLimit: pageLimit, PageFailed: pageFailed}
+	}
+
+	assert.Empty(t, week(weekStatusComplete, 0, 0, false, false).Warning())
+	assert.Equal(t, "acme/app 2025-W10: more than 500 commits, only the first 500 were counted; line counts missing for 450 commits (fetch limit reached)",
+		week(weekStatusTruncated, 450, 0, true, false).Warning())
+	assert.Equal(t, "acme/app 2025-W10: some commits could not be listed (GitHub errors); line counts missing for 2 commits (GitHub errors)",
+		week(weekStatusErrored, 2, 2, false, true).Warning())
+	// Entries of older builds don't say which details failed
+	assert.Equal(t, "acme/app 2025-W10: line counts missing for 3 commits (GitHub errors)",
+		week(weekStatusErrored, 3, 0, false, false).Warning())
+
+	assert.Equal(t, truncatedWeekTTL, weekStatsTTL(weekStatusTruncated))
+	assert.Equal(t, partialWeekTTL, weekStatsTTL(weekStatusErrored))
+}
+
+func TestMigrateOutdatedWeeks(t *testing.T) {
+	dir := initTestRepo(t, "octocat", weekToDate(testPastWeek).Add(24*time.Hour))
+	p, api, store := newTestPlugin(t, dir)
+	expectCommitStorage(api)
+	api.On("LogInfo", "Migrated outdated cached weeks", "count", 1, "schema_version", weeklyStatsSchemaVersion).Return().Once()
+
+	outdated := func(repo, week string) {
+		require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{SchemaVersion: 1, Repo: repo, Week: week}, 0))
+	}
+	// With a limit of one, the untracked repo and the failing week must be passed over
+	outdated("acme/gone", testPastWeek)
+	outdated(testRepo, "2025-W09")
+	outdated(testRepo, testPastWeek)
+	require.NoError(t, store.SetFetchMeta(&FetchMeta{Repo: testRepo, Week: "2025-W09", LastAttemptAt: time.Now().Format(time.RFC3339Nano), Failed: true}))
+
+	assert.Equal(t, 1, p.migrateOutdatedWeeks(1))
+	assert.True(t, p.isCachedUpToDate(testRepo, testPastWeek))
+	assert.False(t, p.isCachedUpToDate(testRepo, "2025-W09"))
+	assert.False(t, p.isCachedUpToDate("acme/gone", testPastWeek))
+}
+
+func TestMigrateOutdatedWeeksRecordsVersion(t *testing.T) {
+	p, api, store := newTestPlugin(t, t.TempDir())
+	kv := newFakeKV(api)
+	require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{SchemaVersion: weeklyStatsSchemaVersion, Repo: testRepo, Week: testPastWeek}, 0))
+
+	// A pass that finds nothing outdated records the version
+	assert.Equal(t, 0, p.migrateOutdatedWeeks(maxMigrationsPerSync))
+	assert.Equal(t, fmt.Sprint(weeklyStatsSchemaVersion), string(kv.data[migratedSchemaKey]))
+
+	// Later sync runs don't scan the cache; the refetch of this week would fail
+	// and log a warning the mock doesn't expect
+	require.NoError(t, store.SetWeeklyStats(&WeeklyRepoStats{SchemaVersion: 1, Repo: testRepo, Week: "2025-W09"}, 0))
+	assert.Equal(t, 0, p.migrateOutdatedWeeks(maxMigrationsPerSync))
+	assert.False(t, p.isCachedUpToDate(testRepo, "2025-W09"))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Mapping suggestion limits
+const (
+	suggestionWeeks      = 12   // weeks of stored commits scanned for identities
+	maxProfileLookups    = 50   // GitHub profiles fetched per request
+	defaultMinConfidence = 0.5  // suggestions below this are dropped
+	ambiguityMargin      = 0.05 // scores within this of the best count as a tie
+)
+
+// Confidence of each matching signal. Several signals are combined as
+// independent evidence, so two weak matches outrank one.
+var suggestionSignals = map[string]float64{
+	"commit email":         1.0,
+	"GitHub profile email": 0.95,
+	"username":             0.9,
+	"nickname":             0.8,
+	"full name":            0.8,
+	"email local part":     0.6,
+	"login matches name":   0.5,
+}
+
+// githubIdentity is what is known about an unmapped GitHub account
+type githubIdentity struct {
+	Login   string
+	ID      int64
+	Names   map[string]bool
+	Emails  map[string]bool // commit emails, lowercased
+	Profile *githubProfile
+	Commits int
+}
+
+type githubProfile struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// MappingSuggestion proposes a Mattermost user for a GitHub account
+type MappingSuggestion struct {
+	GitHubLogin string   `json:"github_login"`
+	GitHubID    int64    `json:"github_id,omitempty"`
+	MMUserID    string   `json:"mm_user_id"`
+	MMUsername  string   `json:"mm_username"`
+	Confidence  float64  `json:"confidence"`
+	Reasons     []string `json:"reasons"`
+	Ambiguous   bool     `json:"ambiguous"` // another user matches about as well
+	Commits     int      `json:"commits"`
+}
+
+// SuggestionsResponse lists suggestions and the unmapped logins without one
+type SuggestionsResponse struct {
+	Suggestions []MappingSuggestion `json:"suggestions"`
+	Unmatched   []string            `json:"unmatched"`
+}
+
+// normalizeIdentity lowercases s and drops everything but letters and digits,
+// so "Jane_Doe", "jane-doe" and "Jane Doe" compare equal
+func normalizeIdentity(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// emailLocalPart returns the part of an email before @ and any +tag
+func emailLocalPart(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+	local, _, _ = strings.Cut(local, "+")
+	return local
+}
+
+// scoreCandidate rates how likely user is the owner of the GitHub account and
+// returns the matching signals
+func scoreCandidate(identity *githubIdentity, user *model.User) (float64, []string) {
+	matched := make(map[string]bool)
+	username := normalizeIdentity(user.Username)
+	login := normalizeIdentity(identity.Login)
+	fullName := normalizeIdentity(user.FirstName + user.LastName)
+
+	for email := range identity.Emails {
+		if user.Email != "" && strings.EqualFold(email, user.Email) {
+			matched["commit email"] = true
+		}
+		if local := normalizeIdentity(emailLocalPart(email)); local != "" && local == username {
+			matched["email local part"] = true
+		}
+	}
+	if identity.Profile != nil {
+		if identity.Profile.Email != "" && strings.EqualFold(identity.Profile.Email, user.Email) {
+			matched["GitHub profile email"] = true
+		}
+		if fullName != "" && normalizeIdentity(identity.Profile.Name) == fullName {
+			matched["full name"] = true
+		}
+	}
+	if login != "" && login == username {
+		matched["username"] = true
+	}
+	if nickname := normalizeIdentity(user.Nickname); nickname != "" && login == nickname {
+		matched["nickname"] = true
+	}
+	if fullName != "" {
+		for name := range identity.Names {
+			if normalizeIdentity(name) == fullName {
+				matched["full name"] = true
+			}
+		}
+		first, last := normalizeIdentity(user.FirstName), normalizeIdentity(user.LastName)
+		// Initials may be multibyte, so they are the first rune
+		initial, _ := utf8.DecodeRuneInString(first)
+		if first != "" && last != "" && (login == fullName || login == string(initial)+last || login == last+string(initial)) {
+			matched["login matches name"] = true
+		}
+	}
+
+	reasons := make([]string, 0, len(matched))
+	miss := 1.0
+	for reason := range matched {
+		reasons = append(reasons, reason)
+		miss *= 1 - suggestionSignals[reason]
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if suggestionSignals[reasons[i]] != suggestionSignals[reasons[j]] {
+			return suggestionSignals[reasons[i]] > suggestionSignals[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	return math.Round((1-miss)*100) / 100, reasons
+}
+
+// suggestMappings proposes the best Mattermost user for each identity
+func suggestMappings(identities []*githubIdentity, users []*model.User, minConfidence float64) *SuggestionsResponse {
+	response := &SuggestionsResponse{Suggestions: []MappingSuggestion{}, Unmatched: []string{}}
+	for _, identity := range identities {
+		var best MappingSuggestion
+		runnerUp := 0.0
+		for _, user := range users {
+			if user.IsBot || user.DeleteAt > 0 {
+				continue
+			}
+			score, reasons := scoreCandidate(identity, user)
+			if score > best.Confidence {
+				runnerUp = best.Confidence
+				best = MappingSuggestion{MMUserID: user.Id, MMUsername: user.Username, Confidence: score, Reasons: reasons}
+			} else if score > runnerUp {
+				runnerUp = score
+			}
+		}
+		if best.MMUserID == "" || best.Confidence < minConfidence {
+			response.Unmatched = append(response.Unmatched, identity.Login)
+			continue
+		}
+		best.GitHubLogin = identity.Login
+		best.GitHubID = identity.ID
+		best.Commits = identity.Commits
+		best.Ambiguous = best.Confidence-runnerUp <= ambiguityMargin
+		response.Suggestions = append(response.Suggestions, best)
+	}
+
+	sort.Slice(response.Suggestions, func(i, j int) bool {
+		a, b := response.Suggestions[i], response.Suggestions[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.GitHubLogin < b.GitHubLogin
+	})
+	sort.Strings(response.Unmatched)
+	return response
+}
+
+// collectUnmappedIdentities gathers the authors of stored commits of the last
+// weeks that aren't mapped yet, with the names and emails they committed with
+func (p *Plugin) collectUnmappedIdentities(config *configuration) []*githubIdentity {
+	mappings := p.getMappings()
+	byLogin := make(map[string]*githubIdentity)
+
+	p.forEachRecentCommit(config, suggestionWeeks, func(record CommitRecord) {
+		login := strings.ToLower(record.Author)
+		if login == "" || mappings[login] != "" {
+			return
+		}
+		identity := byLogin[login]
+		if identity == nil {
+			identity = &githubIdentity{Login: record.Author, Names: make(map[string]bool), Emails: make(map[string]bool)}
+			byLogin[login] = identity
+		}
+		identity.Commits++
+		if record.AuthorName != "" {
+			identity.Names[record.AuthorName] = true
+		}
+		email := strings.ToLower(record.AuthorEmail)
+		if m := noreplyEmailPattern.FindStringSubmatch(email); m != nil {
+			if id, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				identity.ID = id
+			}
+		} else if email != "" {
+			identity.Emails[email] = true
+		}
+	})
+
+	identities := make([]*githubIdentity, 0, len(byLogin))
+	for _, identity := range byLogin {
+		identities = append(identities, identity)
+	}
+	// Most active first, so profile lookups go to the accounts that matter most
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].Commits != identities[j].Commits {
+			return identities[i].Commits > identities[j].Commits
+		}
+		return identities[i].Login < identities[j].Login
+	})
+	return identities
+}
+
+// fetchGitHubProfile returns the public profile of a login, or nil
+func (p *Plugin) fetchGitHubProfile(client *http.Client, login, token string) *githubProfile {
+	resp, err := client.Do(newGitHubRequest(fmt.Sprintf("%s/users/%s", githubAPIURL, login), token))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var profile githubProfile
+	if json.NewDecoder(resp.Body).Decode(&profile) != nil {
+		return nil
+	}
+	return &profile
+}
+
+// handleGetSuggestions proposes mappings for unmapped commit authors (admin only)
+func (p *Plugin) handleGetSuggestions(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+	config := p.getConfiguration()
+
+	minConfidence := defaultMinConfidence
+	if v := r.URL.Query().Get("min_confidence"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			http.Error(w, `{"error": "min_confidence must be between 0 and 1"}`, http.StatusBadRequest)
+			return
+		}
+		minConfidence = parsed
+	}
+
+	identities := p.collectUnmappedIdentities(config)
+	if config.GitHubToken != "" {
+		client := &http.Client{}
+		for i, identity := range identities {
+			if i >= maxProfileLookups || strings.Contains(identity.Login, "@") {
+				continue
+			}
+			identity.Profile = p.fetchGitHubProfile(client, identity.Login, config.GitHubToken)
+			if identity.Profile != nil && identity.ID == 0 {
+				identity.ID = identity.Profile.ID
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(suggestMappings(identities, p.listAllUsers(), minConfidence))
+}
+
+// handleAcceptSuggestions adds the accepted suggestions to the mappings, leaving
+// logins that are mapped today alone (admin only)
+func (p *Plugin) handleAcceptSuggestions(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	var body struct {
+		Mappings []UserMapping `json:"mappings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	if err := p.validateMappings(body.Mappings); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	accepted, skipped := 0, 0
+	err := p.updateMappingSet(r.Header.Get("Mattermost-User-Id"), "suggestions", func(set *MappingSet) error {
+		today := mappingDay()
+		mapped := make(map[string][]UserMapping)
+		for _, m := range set.Mappings {
+			mapped[m.Identity()] = append(mapped[m.Identity()], m)
+		}
+		for _, m := range body.Mappings {
+			ok := true
+			if m.ValidFrom == "" && m.ValidTo == "" {
+				// Only the time since the login's mappings ended is unmapped
+				m, ok = fitPeriod(m, mapped[m.Identity()], today)
+			} else {
+				for _, other := range mapped[m.Identity()] {
+					ok = ok && !m.overlaps(other)
+				}
+			}
+			if !ok {
+				skipped++
+				continue
+			}
+			set.Mappings = append(set.Mappings, m)
+			mapped[m.Identity()] = append(mapped[m.Identity()], m)
+			accepted++
+		}
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to save mappings", "error", err.Error())
+		http.Error(w, `{"error": "failed to save"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"accepted": accepted, "skipped": skipped})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestMappings(t *testing.T) {
+	jane := &model.User{Id: model.NewId(), Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@acme.com"}
+	john := &model.User{Id: model.NewId(), Username: "jsmith", FirstName: "John", LastName: "Smith", Email: "john@acme.com"}
+	johnny := &model.User{Id: model.NewId(), Username: "johnny", Nickname: "jsmith2", FirstName: "John", LastName: "Smith"}
+	bot := &model.User{Id: model.NewId(), Username: "octobot", IsBot: true}
+	users := []*model.User{jane, john, johnny, bot}
+
+	identities := []*githubIdentity{
+		{Login: "janed", Emails: map[string]bool{"jane@acme.com": true}, Names: map[string]bool{}},
+		{Login: "jane-doe", Emails: map[string]bool{}, Names: map[string]bool{}},
+		{Login: "smithy", Emails: map[string]bool{}, Names: map[string]bool{"John Smith": true}},
+		{Login: "octobot", Emails: map[string]bool{}, Names: map[string]bool{}},
+	}
+
+	response := suggestMappings(identities, users, defaultMinConfidence)
+	require.Len(t, response.Suggestions, 3)
+
+	// Commit email outranks everything
+	assert.Equal(t, "janed", response.Suggestions[0].GitHubLogin)
+	assert.Equal(t, jane.Id, response.Suggestions[0].MMUserID)
+	assert.Equal(t, 1.0, response.Suggestions[0].Confidence)
+
+	assert.Equal(t, "jane-doe", response.Suggestions[1].GitHubLogin)
+	assert.Equal(t, []string{"username", "login matches name"}, response.Suggestions[1].Reasons)
+	assert.Equal(t, 0.95, response.Suggestions[1].Confidence)
+	assert.False(t, response.Suggestions[1].Ambiguous)
+
+	// Two users are named John Smith
+	assert.Equal(t, "smithy", response.Suggestions[2].GitHubLogin)
+	assert.True(t, response.Suggestions[2].Ambiguous)
+
+	// Bots aren't suggested
+	assert.Equal(t, []string{"octobot"}, response.Unmatched)
+}
+
+func TestAcceptSuggestions(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+
+	admin := &model.User{Id: model.NewId(), Username: "admin", Roles: model.SystemAdminRoleId}
+	api.On("GetUser", admin.Id).Return(admin, nil)
+	api.On("GetUser", mock.Anything).Return(&model.User{}, nil)
+
+	alice, bob, carol := model.NewId(), model.NewId(), model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: bob},
+		{GitHubLogin: "octocat", MMUserID: alice, ValidTo: "2024-12-31"},
+	}}))
+
+	// A login whose mapping ended is free from the day after; one mapped today is not
+	body := `{"mappings": [{"github_login": "octocat", "mm_user_id": "` + carol + `"}, {"github_login": "hubot", "mm_user_id": "` + carol + `"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/mappings/suggestions/accept", strings.NewReader(body))
+	r.Header.Set("Mattermost-User-Id", admin.Id)
+	w := httptest.NewRecorder()
+	p.handleAcceptSuggestions(w, r)
+
+	var result map[string]int
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, map[string]int{"accepted": 1, "skipped": 1}, result)
+	assert.Equal(t, []UserMapping{
+		{GitHubLogin: "hubot", MMUserID: bob},
+		{GitHubLogin: "octocat", MMUserID: alice, ValidTo: "2024-12-31"},
+		{GitHubLogin: "octocat", MMUserID: carol, ValidFrom: "2025-01-01"},
+	}, p.getMappingSet().Mappings)
+}
+
+func TestScoreCandidateMatchesInitials(t *testing.T) {
+	tests := []struct {
+		login, first, last string
+		want               bool
+	}{
+		{"jdoe", "Jane", "Doe", true},
+		{"doej", "Jane", "Doe", true},
+		{"janedoe", "Jane", "Doe", true},
+		{"ézola", "Émile", "Zola", true},
+		{"зольдатов", "Зиновий", "Ольдатов", true},
+		{"xdoe", "Jane", "Doe", false},
+		{"doe", "Jane", "Doe", false},
+	}
+	for _, tt := range tests {
+		identity := &githubIdentity{Login: tt.login, Names: map[string]bool{}, Emails: map[string]bool{}}
+		user := &model.User{Username: "someone", FirstName: tt.first, LastName: tt.last}
+		_, reasons := scoreCandidate(identity, user)
+		assert.Equal(t, tt.want, slices.Contains(reasons, "login matches name"), tt.login)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	syncJobKey                 = "github_reports_sync"
+	defaultSyncWeeks           = 8
+	defaultSyncIntervalMinutes = 5
+
+	// With sync enabled, a current-week snapshot older than this many sync
+	// intervals is revalidated on read; the sync job is assumed to be stuck
+	currentSnapshotMaxIntervals = 3
+
+	// Current-week snapshots are only read during their week; the expiry
+	// removes them once the week has rolled over
+	currentWeekTTL = 14 * 24 * time.Hour
+
+	// statsUpdatedEvent tells clients that fresher current-week stats are stored
+	statsUpdatedEvent = "stats_updated"
+)
+
+// syncWeeks returns how many past weeks the sync job keeps warm
+func syncWeeks(config *configuration) int {
+	if config.SyncWeeks > 0 {
+		return config.SyncWeeks
+	}
+	return defaultSyncWeeks
+}
+
+// syncInterval returns how often the sync job refreshes the current week
+func syncInterval(config *configuration) time.Duration {
+	if config.SyncIntervalMinutes > 0 {
+		return time.Duration(config.SyncIntervalMinutes) * time.Minute
+	}
+	return defaultSyncIntervalMinutes * time.Minute
+}
+
+// setupSyncJob (re)schedules the background sync job to match the configuration.
+// The job is cluster-safe: only one node runs it at a time.
+func (p *Plugin) setupSyncJob() {
+	p.syncJobLock.Lock()
+	defer p.syncJobLock.Unlock()
+
+	if p.syncJob != nil {
+		if err := p.syncJob.Close(); err != nil {
+			p.API.LogWarn("Failed to stop sync job", "error", err.Error())
+		}
+		p.syncJob = nil
+	}
+
+	config := p.getConfiguration()
+	if !config.SyncEnabled {
+		return
+	}
+
+	job, err := cluster.Schedule(p.API, syncJobKey, cluster.MakeWaitForInterval(syncInterval(config)), p.runSync)
+	if err != nil {
+		p.API.LogError("Failed to schedule sync job", "error", err.Error())
+		return
+	}
+	p.syncJob = job
+}
+
+// stopSyncJob stops the background sync job, if running
+func (p *Plugin) stopSyncJob() {
+	p.syncJobLock.Lock()
+	defer p.syncJobLock.Unlock()
+
+	if p.syncJob != nil {
+		p.syncJob.Close()
+		p.syncJob = nil
+	}
+}
+
+// runSync refreshes the current week of every tracked repo and fetches any
+// of the last N weeks missing from the cache
+func (p *Plugin) runSync() {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		return
+	}
+
+	currentWeek := currentISOWeek()
+	pastWeeks := lastWeeks(currentWeek, syncWeeks(config))
+
+	for _, repo := range trackedRepos(config) {
+		if !repoHasSource(config, repo) {
+			continue
+		}
+		if stats := p.refreshWeeklyStats(repo, currentWeek, true, config.GitHubToken); stats != nil {
+			p.publishStatsUpdated(stats)
+		}
+
+		for _, week := range pastWeeks {
+			if p.isCachedUpToDate(repo, week) {
+				continue
+			}
+			p.refreshWeeklyStats(repo, week, false, config.GitHubToken)
+		}
+	}
+
+	// Upgrade older cached weeks a batch at a time
+	p.migrateOutdatedWeeks(maxMigrationsPerSync)
+}
+
+// lastWeeks returns the n ISO weeks before week, oldest first
+func lastWeeks(week string, n int) []string {
+	start := weekToDate(week)
+	weeks := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		year, wn := start.AddDate(0, 0, -7*i).ISOWeek()
+		weeks = append(weeks, isoWeekString(year, wn))
+	}
+	return weeks
+}
+
+// saveCurrentWeekSnapshot stores the latest current-week stats. It is kept under
+// its own key so an in-progress week never ends up in the permanent cache.
+func (p *Plugin) saveCurrentWeekSnapshot(stats *WeeklyRepoStats) {
+	if err := p.getStore().SetCurrentWeek(stats); err != nil {
+		p.API.LogWarn("Failed to save current week snapshot", "repo", stats.Repo, "error", err.Error())
+	}
+}
+
+// loadCurrentWeekSnapshot returns the last stored current-week snapshot of any
+// age, or nil if it is missing or outdated
+func (p *Plugin) loadCurrentWeekSnapshot(repo, week string) *WeeklyRepoStats {
+	snapshot, err := p.getStore().GetCurrentWeek(repo, week)
+	if err != nil || snapshot == nil || snapshot.Outdated() {
+		return nil
+	}
+	return snapshot
+}
+
+// snapshotStale reports whether a current-week snapshot should be revalidated.
+// With sync enabled the job keeps snapshots fresh, so they only go stale when it
+// falls several intervals behind.
+func (p *Plugin) snapshotStale(snapshot *WeeklyRepoStats) bool {
+	config := p.getConfiguration()
+	maxAge := syncInterval(config)
+	if config.SyncEnabled {
+		maxAge *= currentSnapshotMaxIntervals
+	}
+
+	fetchedAt, err := time.Parse(time.RFC3339, snapshot.FetchedAt)
+	return err != nil || time.Since(fetchedAt) > maxAge
+}
+
+// revalidateCurrentWeek refreshes a current-week snapshot in the background and
+// notifies clients when it is stored. Only one refresh per repo-week runs at a time.
+func (p *Plugin) revalidateCurrentWeek(repo, week, token string) {
+	key := repoWeekKey("gh_current", repo, week)
+	if _, running := p.revalidating.LoadOrStore(key, true); running {
+		return
+	}
+
+	go func() {
+		defer p.revalidating.Delete(key)
+		if stats := p.refreshWeeklyStats(repo, week, true, token); stats != nil {
+			p.publishStatsUpdated(stats)
+		}
+	}()
+}
+
+// isRevalidating reports whether a background refresh of a repo-week is in flight
+func (p *Plugin) isRevalidating(repo, week string) bool {
+	_, running := p.revalidating.Load(repoWeekKey("gh_current", repo, week))
+	return running
+}
+
+// publishStatsUpdated notifies all clients that fresher stats of a repo-week are stored
+func (p *Plugin) publishStatsUpdated(stats *WeeklyRepoStats) {
+	p.API.PublishWebSocketEvent(statsUpdatedEvent, map[string]interface{}{
+		"repo":       stats.Repo,
+		"week":       stats.Week,
+		"fetched_at": stats.FetchedAt,
+	}, &model.WebsocketBroadcast{})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Team sources accepted by the team stats endpoint
+const (
+	teamSourceTeams  = "teams"
+	teamSourceGroups = "groups"
+	teamSourceConfig = "config"
+)
+
+// TeamStats represents aggregated stats for a team of mapped users
+type TeamStats struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Commits int            `json:"commits"`
+	Added   int            `json:"added"`
+	Removed int            `json:"removed"`
+	ByRepo  map[string]int `json:"by_repo"`
+	Members []UserStats    `json:"members"`
+}
+
+// TeamStatsResponse represents the team stats response
+type TeamStatsResponse struct {
+	Source      string      `json:"source"`
+	Teams       []TeamStats `json:"teams"`
+	WeekStart   string      `json:"week_start"`
+	WeekEnd     string      `json:"week_end"`
+	LastUpdated string      `json:"last_updated"`
+}
+
+// teamRef identifies a team a user belongs to
+type teamRef struct {
+	ID   string
+	Name string
+}
+
+// handleGetTeamStats aggregates mapped users into teams.
+// source=teams uses Mattermost teams, source=groups uses user groups,
+// source=config uses the named groups from plugin settings.
+func (p *Plugin) handleGetTeamStats(w http.ResponseWriter, r *http.Request) {
+	config := p.getConfiguration()
+	if !hasStatsSource(config) {
+		http.Error(w, `{"error": "GitHub token or local repositories not configured"}`, http.StatusBadRequest)
+		return
+	}
+
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = teamSourceTeams
+	}
+
+	var membership func(mmUserID string) []teamRef
+	switch source {
+	case teamSourceTeams:
+		membership = p.mattermostTeamsForUser
+	case teamSourceGroups:
+		membership = p.userGroupsForUser
+	case teamSourceConfig:
+		configGroups, err := p.configTeamGroups(config)
+		if err != nil {
+			http.Error(w, `{"error": "invalid team groups configuration"}`, http.StatusBadRequest)
+			return
+		}
+		membership = func(mmUserID string) []teamRef {
+			return configGroups[mmUserID]
+		}
+	default:
+		http.Error(w, `{"error": "source must be one of teams, groups, config"}`, http.StatusBadRequest)
+		return
+	}
+
+	weekStart, weekEnd := weekRangeFromQuery(r)
+	stats := p.collectStats(config, weekStart, weekEnd)
+
+	response := TeamStatsResponse{
+		Source:      source,
+		Teams:       aggregateTeams(stats.Users, membership),
+		WeekStart:   weekStart,
+		WeekEnd:     weekEnd,
+		LastUpdated: stats.LastUpdated,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// aggregateTeams sums mapped users into the teams returned by membership.
+// Unmapped GitHub logins are skipped since they can't belong to a team.
+func aggregateTeams(users []UserStats, membership func(mmUserID string) []teamRef) []TeamStats {
+	teams := make(map[string]*TeamStats)
+
+	for _, u := range users {
+		if u.MMUserID == "" {
+			continue
+		}
+		for _, ref := range membership(u.MMUserID) {
+			team := teams[ref.ID]
+			if team == nil {
+				team = &TeamStats{
+					ID:     ref.ID,
+					Name:   ref.Name,
+					ByRepo: make(map[string]int),
+				}
+				teams[ref.ID] = team
+			}
+			team.Commits += u.Commits
+			team.Added += u.Added
+			team.Removed += u.Removed
+			for repo, commits := range u.ByRepo {
+				team.ByRepo[repo] += commits
+			}
+			team.Members = append(team.Members, u)
+		}
+	}
+
+	result := make([]TeamStats, 0, len(teams))
+	for _, team := range teams {
+		sortUserStats(team.Members)
+		result = append(result, *team)
+	}
+
+	// Sort by commits desc, then name for stable output
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Commits != result[j].Commits {
+			return result[i].Commits > result[j].Commits
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// mattermostTeamsForUser returns the Mattermost teams a user is a member of
+func (p *Plugin) mattermostTeamsForUser(mmUserID string) []teamRef {
+	teams, err := p.API.GetTeamsForUser(mmUserID)
+	if err != nil {
+		p.API.LogWarn("Failed to get teams for user", "user_id", mmUserID, "error", err.Error())
+		return nil
+	}
+
+	refs := make([]teamRef, 0, len(teams))
+	for _, t := range teams {
+		refs = append(refs, teamRef{ID: t.Id, Name: t.DisplayName})
+	}
+	return refs
+}
+
+// userGroupsForUser returns the user groups (custom or synced) a user is a member of
+func (p *Plugin) userGroupsForUser(mmUserID string) []teamRef {
+	groups, err := p.API.GetGroupsForUser(mmUserID)
+	if err != nil {
+		p.API.LogWarn("Failed to get groups for user", "user_id", mmUserID, "error", err.Error())
+		return nil
+	}
+
+	refs := make([]teamRef, 0, len(groups))
+	for _, g := range groups {
+		if g.DeleteAt != 0 {
+			continue
+		}
+		refs = append(refs, teamRef{ID: g.Id, Name: g.DisplayName})
+	}
+	return refs
+}
+
+// configTeamGroups resolves the team_groups setting ({"Backend": ["alice", "bob"]})
+// into MM user ID -> groups. Members are Mattermost usernames.
+func (p *Plugin) configTeamGroups(config *configuration) (map[string][]teamRef, error) {
+	groups := make(map[string][]string)
+	if strings.TrimSpace(config.TeamGroups) != "" {
+		if err := json.Unmarshal([]byte(config.TeamGroups), &groups); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]teamRef)
+	for name, usernames := range groups {
+		if len(usernames) == 0 {
+			continue
+		}
+		users, err := p.API.GetUsersByUsernames(usernames)
+		if err != nil {
+			p.API.LogWarn("Failed to resolve team group members", "group", name, "error", err.Error())
+			continue
+		}
+		for _, u := range users {
+			result[u.Id] = append(result[u.Id], teamRef{ID: name, Name: name})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	unmappedReportJobKey = "github_reports_unmapped_report"
+	defaultUnmappedWeeks = 4
+	maxUnmappedWeeks     = 52
+	maxReportRows        = 20 // contributors listed in a report DM
+)
+
+// botUsername is the account the plugin posts as
+const botUsername = "github-reports"
+
+// UnmappedContributor is a commit author of the tracked repos without a mapping
+type UnmappedContributor struct {
+	Identity     string   `json:"identity"` // GitHub login, or email without an account
+	Name         string   `json:"name,omitempty"`
+	Commits      int      `json:"commits"`
+	Added        int      `json:"added"`
+	Removed      int      `json:"removed"`
+	Repos        []string `json:"repos"`
+	LastActivity string   `json:"last_activity"` // RFC3339
+}
+
+// unmappedContributors lists the authors of the stored commits of the last
+// weeks that weren't mapped when committing, most commits first. GitHub App bots are left out;
+// there is nobody to map them to.
+func (p *Plugin) unmappedContributors(config *configuration, weeks int) []UnmappedContributor {
+	mappings := p.getMappingIndex()
+	byIdentity := make(map[string]*UnmappedContributor)
+	repos := make(map[string]map[string]bool)
+
+	p.forEachRecentCommit(config, weeks, func(record CommitRecord) {
+		identity := strings.ToLower(record.Author)
+		if identity == "" || strings.HasSuffix(identity, "[bot]") {
+			return
+		}
+		if at, _ := time.Parse(time.RFC3339, record.AuthoredAt); mappings.userAt(identity, at) != "" {
+			return
+		}
+		c := byIdentity[identity]
+		if c == nil {
+			c = &UnmappedContributor{Identity: record.Author}
+			byIdentity[identity] = c
+			repos[identity] = make(map[string]bool)
+		}
+		c.Commits++
+		c.Added += record.Added
+		c.Removed += record.Removed
+		repos[identity][record.Repo] = true
+		if record.AuthoredAt > c.LastActivity {
+			c.Identity = record.Author // as spelled most recently
+			c.LastActivity = record.AuthoredAt
+			if record.AuthorName != "" {
+				c.Name = record.AuthorName
+			}
+		}
+	})
+
+	contributors := make([]UnmappedContributor, 0, len(byIdentity))
+	for identity, c := range byIdentity {
+		c.Repos = sortedIdentities(repos[identity])
+		contributors = append(contributors, *c)
+	}
+	sort.Slice(contributors, func(i, j int) bool {
+		a, b := contributors[i], contributors[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		if a.Added+a.Removed != b.Added+b.Removed {
+			return a.Added+a.Removed > b.Added+b.Removed
+		}
+		if a.LastActivity != b.LastActivity {
+			return a.LastActivity > b.LastActivity
+		}
+		return a.Identity < b.Identity
+	})
+	return contributors
+}
+
+// handleGetUnmapped lists unmapped contributors of the last weeks (admin only).
+// Query: weeks (default 4, at most 52).
+func (p *Plugin) handleGetUnmapped(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	weeks := defaultUnmappedWeeks
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUnmappedWeeks {
+			http.Error(w, fmt.Sprintf(`{"error": "weeks must be between 1 and %d"}`, maxUnmappedWeeks), http.StatusBadRequest)
+			return
+		}
+		weeks = n
+	}
+
+	json.NewEncoder(w).Encode(p.unmappedContributors(p.getConfiguration(), weeks))
+}
+
+// setupReportJob (re)schedules the unmapped contributors report DM to match the
+// configuration. Like the sync job it runs on one cluster node at a time.
+func (p *Plugin) setupReportJob() {
+	p.reportJobLock.Lock()
+	defer p.reportJobLock.Unlock()
+
+	if p.reportJob != nil {
+		if err := p.reportJob.Close(); err != nil {
+			p.API.LogWarn("Failed to stop unmapped report job", "error", err.Error())
+		}
+		p.reportJob = nil
+	}
+
+	config := p.getConfiguration()
+	if config.UnmappedReportDays <= 0 {
+		return
+	}
+
+	interval := time.Duration(config.UnmappedReportDays) * 24 * time.Hour
+	job, err := cluster.Schedule(p.API, unmappedReportJobKey, cluster.MakeWaitForInterval(interval), p.sendUnmappedReport)
+	if err != nil {
+		p.API.LogError("Failed to schedule unmapped report job", "error", err.Error())
+		return
+	}
+	p.reportJob = job
+}
+
+// stopReportJob stops the report job, if running
+func (p *Plugin) stopReportJob() {
+	p.reportJobLock.Lock()
+	defer p.reportJobLock.Unlock()
+
+	if p.reportJob != nil {
+		p.reportJob.Close()
+		p.reportJob = nil
+	}
+}
+
+// sendUnmappedReport DMs every system admin the unmapped contributors of the
+// last weeks. Nothing is sent while everyone is mapped.
+func (p *Plugin) sendUnmappedReport() {
+	config := p.getConfiguration()
+	contributors := p.unmappedContributors(config, defaultUnmappedWeeks)
+	if len(contributors) == 0 {
+		return
+	}
+
+	botID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    botUsername,
+		DisplayName: "GitHub Reports",
+		Description: "Posts GitHub Activity Reports notifications.",
+	})
+	if err != nil {
+		p.API.LogError("Failed to ensure bot", "error", err.Error())
+		return
+	}
+
+	admins, appErr := p.API.GetUsers(&model.UserGetOptions{Role: model.SystemAdminRoleId, Active: true, PerPage: 200})
+	if appErr != nil {
+		p.API.LogError("Failed to list system admins", "error", appErr.Error())
+		return
+	}
+
+	message := unmappedReportMessage(contributors, defaultUnmappedWeeks)
+	for _, admin := range admins {
+		if admin.IsBot {
+			continue
+		}
+		channel, appErr := p.API.GetDirectChannel(botID, admin.Id)
+		if appErr != nil {
+			p.API.LogWarn("Failed to open DM for unmapped report", "user_id", admin.Id, "error", appErr.Error())
+			continue
+		}
+		if _, appErr := p.API.CreatePost(&model.Post{UserId: botID, ChannelId: channel.Id, Message: message}); appErr != nil {
+			p.API.LogWarn("Failed to send unmapped report", "user_id", admin.Id, "error", appErr.Error())
+		}
+	}
+}
+
+// unmappedReportMessage renders the report as a Markdown table
+func unmappedReportMessage(contributors []UnmappedContributor, weeks int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Unmapped GitHub contributors\n%d commit authors of the last %d weeks have no Mattermost user, so their activity shows under their raw GitHub name. ", len(contributors), weeks)
+	b.WriteString("Map them in **System Console → Plugins → GitHub Activity Reports**.\n\n")
+	b.WriteString("| Contributor | Commits | Lines | Repositories | Last activity |\n|---|---|---|---|---|\n")
+	for i, c := range contributors {
+		if i == maxReportRows {
+			fmt.Fprintf(&b, "\n…and %d more.", len(contributors)-maxReportRows)
+			break
+		}
+		name := c.Identity
+		if c.Name != "" {
+			name += " (" + c.Name + ")"
+		}
+		name = strings.ReplaceAll(name, "|", `\|`)
+		lastActivity := c.LastActivity
+		if t, err := time.Parse(time.RFC3339, c.LastActivity); err == nil {
+			lastActivity = t.Format("2006-01-02")
+		}
+		fmt.Fprintf(&b, "| %s | %d | +%d −%d | %s | %s |\n", name, c.Commits, c.Added, c.Removed, strings.Join(c.Repos, ", "), lastActivity)
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmappedContributors(t *testing.T) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	newFakeKV(api)
+	store := newMemoryStore()
+	p := &Plugin{store: store}
+	p.SetAPI(api)
+	config := &configuration{Repositories: "acme/api, acme/web"}
+	p.setConfiguration(config)
+
+	jane := model.NewId()
+	require.NoError(t, store.SetMappings(&MappingSet{Version: mappingSetVersion, Mappings: []UserMapping{
+		{GitHubLogin: "jane", MMUserID: jane},
+	}}))
+
+	now := time.Now().UTC()
+	current := currentISOWeek()
+	previous := lastWeeks(current, 1)[0]
+	stats := &WeeklyRepoStats{Status: weekStatusComplete}
+	record := func(repo, sha, author, email string, at time.Time, added int) CommitRecord {
+		return CommitRecord{SHA: sha, Repo: repo, Author: author, AuthorName: "Name " + sha, AuthorEmail: email, AuthoredAt: at.Format(time.RFC3339), Added: added}
+	}
+	p.saveCommitRecords("acme/api", previous, []CommitRecord{
+		record("acme/api", "a1", "Octocat", "", now.AddDate(0, 0, -7), 10),
+		record("acme/api", "a2", "jane", "", now.AddDate(0, 0, -7), 50),
+		record("acme/api", "a3", "", "dev@example.com", now.AddDate(0, 0, -8), 500),
+	}, stats)
+	p.saveCommitRecords("acme/web", current, []CommitRecord{
+		record("acme/web", "b1", "octocat", "", now, 1),
+		record("acme/web", "b2", "dependabot[bot]", "", now, 1),
+		record("acme/web", "b3", "hubot", "", now, 5),
+	}, stats)
+
+	contributors := p.unmappedContributors(config, 4)
+	require.Len(t, contributors, 3)
+	assert.Equal(t, UnmappedContributor{
+		Identity:     "octocat",
+		Name:         "Name b1",
+		Commits:      2,
+		Added:        11,
+		Repos:        []string{"acme/api", "acme/web"},
+		LastActivity: now.Format(time.RFC3339),
+	}, contributors[0])
+	// Equal commit counts are ordered by lines changed
+	assert.Equal(t, "dev@example.com", contributors[1].Identity)
+	assert.Equal(t, "hubot", contributors[2].Identity)
+
+	// The report goes to every human system admin
+	admin := &model.User{Id: model.NewId(), Roles: model.SystemAdminRoleId}
+	botID := model.NewId()
+	api.On("EnsureBotUser", mock.AnythingOfType("*model.Bot")).Return(botID, nil).Once()
+	api.On("GetUsers", mock.MatchedBy(func(o *model.UserGetOptions) bool { return o.Role == model.SystemAdminRoleId })).
+		Return([]*model.User{admin, {Id: model.NewId(), IsBot: true}}, nil).Once()
+	api.On("GetDirectChannel", botID, admin.Id).Return(&model.Channel{Id: "dm"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.UserId == botID && post.ChannelId == "dm" &&
+			assert.Contains(t, post.Message, "| octocat (Name b1) | 2 | +11 −0 | acme/api, acme/web |")
+	})).Return(&model.Post{}, nil).Once()
+	p.sendUnmappedReport()
+}
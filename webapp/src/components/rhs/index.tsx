@@ -3,6 +3,9 @@ import './styles.css';
 
 const PLUGIN_ID = 'com.fambear.github-reports';
 
+// Window event fired when the server publishes fresher stats for a repo-week
+export const STATS_UPDATED_EVENT = 'github-reports:stats-updated';
+
 interface UserStats {
     mm_user_id: string;
     mm_username: string;
@@ -11,6 +14,7 @@ interface UserStats {
     added: number;
     removed: number;
     by_repo: Record<string, number>;
+    identities?: string[];
 }
 
 interface StatsResponse {
@@ -19,8 +23,23 @@ interface StatsResponse {
     week_start: string;
     week_end: string;
     last_updated: string;
+    warnings?: string[];
+    current_week_fetched_at?: string;
+    refreshing?: boolean;
 }
 
+// Human readable age of an RFC3339 timestamp
+const formatAge = (date: string): string => {
+    const minutes = Math.floor((Date.now() - new Date(date).getTime()) / 60000);
+    if (minutes < 1) {
+        return 'just now';
+    }
+    if (minutes < 60) {
+        return `${minutes} min ago`;
+    }
+    return `${Math.floor(minutes / 60)} h ago`;
+};
+
 // Get ISO week number from date
 const getISOWeek = (date: Date): { year: number; week: number } => {
     const d = new Date(Date.UTC(date.getFullYear(), date.getMonth(), date.getDate()));
@@ -180,9 +199,11 @@ const GitHubReportsRHS: React.FC = () => {
     const [loading, setLoading] = useState(false);
     const [error, setError] = useState<string | null>(null);
 
-    // Fetch stats from API
-    const fetchStats = useCallback(async () => {
-        setLoading(true);
+    // Fetch stats from API. Background refetches keep the current view visible.
+    const fetchStats = useCallback(async (background = false) => {
+        if (!background) {
+            setLoading(true);
+        }
         setError(null);
         try {
             const url = `/plugins/${PLUGIN_ID}/api/v1/stats?week_start=${weekStart}&week_end=${weekEnd}`;
@@ -211,6 +232,18 @@ const GitHubReportsRHS: React.FC = () => {
         fetchStats();
     }, [fetchStats]);
 
+    // Refetch when the server reports fresher data for a week in view
+    useEffect(() => {
+        const onStatsUpdated = (e: Event) => {
+            const week = (e as CustomEvent).detail?.week;
+            if (week && week >= weekStart && week <= weekEnd) {
+                fetchStats(true);
+            }
+        };
+        window.addEventListener(STATS_UPDATED_EVENT, onStatsUpdated);
+        return () => window.removeEventListener(STATS_UPDATED_EVENT, onStatsUpdated);
+    }, [fetchStats, weekStart, weekEnd]);
+
     // Toggle user selection
     const toggleUser = (userId: string) => {
         setSelectedUsers(prev => {
@@ -331,6 +364,16 @@ const GitHubReportsRHS: React.FC = () => {
             {/* Stats Overview */}
             {!loading && stats && (
                 <>
+                    {/* Partial weeks */}
+                    {stats.warnings && stats.warnings.length > 0 && (
+                        <div className="warning-message">
+                            Some weeks are incomplete:
+                            <ul>
+                                {stats.warnings.map(w => <li key={w}>{w}</li>)}
+                            </ul>
+                        </div>
+                    )}
+
                     <div className="stats-overview">
                         <div className="stat-card">
                             <div className="stat-value">{totalCommits.toLocaleString()}</div>
@@ -356,7 +399,7 @@ const GitHubReportsRHS: React.FC = () => {
                                 return (
                                     <div key={user.mm_user_id || user.name} className="user-row">
                                         <div className="user-info">
-                                            <span className="user-name">
+                                            <span className="user-name" title={user.identities?.join(', ')}>
                                                 {user.mm_username ? `@${user.mm_username}` : user.name}
                                             </span>
                                             <span className="user-commits">{user.commits} commits</span>
@@ -377,8 +420,16 @@ const GitHubReportsRHS: React.FC = () => {
                     </div>
 
                     <div className="rhs-footer">
-                        <span>Last updated: {new Date(stats.last_updated).toLocaleString()}</span>
-                        <button className="refresh-btn" onClick={fetchStats}>↻</button>
+                        <span>
+                            Last updated: {new Date(stats.last_updated).toLocaleString()}
+                            {stats.current_week_fetched_at && (
+                                <span className="data-age">
+                                    {' · '}This week from {formatAge(stats.current_week_fetched_at)}
+                                    {stats.refreshing && ', refreshing…'}
+                                </span>
+                            )}
+                        </span>
+                        <button className="refresh-btn" onClick={() => fetchStats()}>↻</button>
                     </div>
                 </>
             )}
@@ -2,6 +2,7 @@ import React, { useState, useEffect, useCallback } from 'react';
 import './styles.css';
 
 interface GitHubUser {
+    id?: number;
     login: string;
     avatar_url: string;
     name?: string;
@@ -30,6 +31,59 @@ interface MMUser {
     delete_at?: number;
 }
 
+// A mapping matches either a GitHub login or a commit email
+interface UserMapping {
+    github_login?: string;
+    github_id?: number;
+    email?: string;
+    mm_user_id: string;
+    source?: 'profile';
+    valid_from?: string; // YYYY-MM-DD, inclusive
+    valid_to?: string;
+}
+
+const mappingIdentity = (m: UserMapping) => (m.github_login || m.email || '').toLowerCase();
+
+// An identity can have several mappings with different validity periods
+const mappingKey = (m: UserMapping) => `${mappingIdentity(m)}|${m.valid_from || ''}`;
+
+interface UnresolvedMapping {
+    key: string;
+    value: string;
+    reason: string;
+}
+
+interface MappingSuggestion {
+    github_login: string;
+    github_id?: number;
+    mm_user_id: string;
+    mm_username: string;
+    confidence: number;
+    reasons: string[];
+    ambiguous: boolean;
+    commits: number;
+}
+
+interface ImportChange {
+    action: 'create' | 'update' | 'delete';
+    identity: string;
+    before?: UserMapping;
+    after?: UserMapping;
+}
+
+interface ImportResult {
+    dry_run: boolean;
+    applied: boolean;
+    errors: Array<{line: number; identity?: string; error: string}>;
+    changes: ImportChange[];
+    created: number;
+    updated: number;
+    deleted: number;
+}
+
+// Suggestions at or above this confidence are preselected unless ambiguous
+const PRESELECT_CONFIDENCE = 0.9;
+
 interface UserMappingsProps {
     id: string;
     label: string;
@@ -42,14 +96,12 @@ interface UserMappingsProps {
 const PLUGIN_ID = 'com.fambear.github-reports';
 
 export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
-    id,
     label,
     helpText,
-    value,
-    onChange,
-    setSaveNeeded,
 }) => {
-    const [mappings, setMappings] = useState<Record<string, string>>({});
+    const [mappings, setMappings] = useState<Record<string, UserMapping>>({});
+    const [unresolved, setUnresolved] = useState<UnresolvedMapping[]>([]);
+    const [revision, setRevision] = useState(0);
     const [githubUsers, setGithubUsers] = useState<GitHubUser[]>([]);
     const [mmUsers, setMmUsers] = useState<MMUser[]>([]);
     const [contributors, setContributors] = useState<ContributorWithCommits[]>([]);
@@ -59,18 +111,112 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
     const [searchGH, setSearchGH] = useState('');
     const [searchMM, setSearchMM] = useState('');
     const [activeDropdown, setActiveDropdown] = useState<string | null>(null);
+    const [suggestions, setSuggestions] = useState<MappingSuggestion[] | null>(null);
+    const [selectedSuggestions, setSelectedSuggestions] = useState<Record<string, boolean>>({});
+    const [loadingSuggestions, setLoadingSuggestions] = useState(false);
+    const [importFile, setImportFile] = useState<File | null>(null);
+    const [importMode, setImportMode] = useState<'replace' | 'merge'>('replace');
+    const [importResult, setImportResult] = useState<ImportResult | null>(null);
     const mmInputRef = React.useRef<HTMLInputElement>(null);
 
-    // Parse initial value
-    useEffect(() => {
+    // Load mappings from the plugin, which imported the System Console value once
+    const fetchMappings = useCallback(async () => {
         try {
-            const parsed = value ? JSON.parse(value) : {};
-            setMappings(parsed);
-        } catch {
-            setMappings({});
+            const res = await fetch(`/plugins/${PLUGIN_ID}/api/v1/mappings`);
+            if (res.ok) {
+                const data = await res.json();
+                const byLogin: Record<string, UserMapping> = {};
+                (data?.mappings || []).forEach((m: UserMapping) => {
+                    byLogin[mappingKey(m)] = m;
+                });
+                setMappings(byLogin);
+                setUnresolved(data?.unresolved || []);
+                setRevision(data?.revision || 0);
+            } else {
+                setError('Failed to load mappings');
+            }
+        } catch (err) {
+            setError('Failed to load mappings');
         }
     }, []);
 
+    useEffect(() => {
+        fetchMappings();
+    }, [fetchMappings]);
+
+    const fetchSuggestions = async () => {
+        setLoadingSuggestions(true);
+        try {
+            const res = await fetch(`/plugins/${PLUGIN_ID}/api/v1/mappings/suggestions`);
+            if (!res.ok) {
+                throw new Error(res.statusText);
+            }
+            const data = await res.json();
+            const list: MappingSuggestion[] = data?.suggestions || [];
+            const selected: Record<string, boolean> = {};
+            list.forEach(s => {
+                selected[s.github_login] = !s.ambiguous && s.confidence >= PRESELECT_CONFIDENCE;
+            });
+            setSuggestions(list);
+            setSelectedSuggestions(selected);
+        } catch (err) {
+            setError('Failed to load suggestions');
+        } finally {
+            setLoadingSuggestions(false);
+        }
+    };
+
+    const acceptSuggestions = async () => {
+        const accepted = (suggestions || [])
+            .filter(s => selectedSuggestions[s.github_login])
+            .map(s => ({ github_login: s.github_login, github_id: s.github_id, mm_user_id: s.mm_user_id }));
+        if (accepted.length === 0) {
+            return;
+        }
+        try {
+            const res = await fetch(`/plugins/${PLUGIN_ID}/api/v1/mappings/suggestions/accept`, {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-Requested-With': 'XMLHttpRequest'},
+                body: JSON.stringify({mappings: accepted}),
+            });
+            if (!res.ok) {
+                throw new Error(res.statusText);
+            }
+            setSuggestions(null);
+            setError(null);
+            await fetchMappings();
+        } catch (err) {
+            setError('Failed to accept suggestions');
+        }
+    };
+
+    // Uploads the chosen file; a dry run only previews the changes
+    const importMappings = async (dryRun: boolean) => {
+        if (!importFile) {
+            return;
+        }
+        const format = importFile.name.toLowerCase().endsWith('.json') ? 'json' : 'csv';
+        try {
+            const res = await fetch(`/plugins/${PLUGIN_ID}/api/v1/mappings/import?format=${format}&mode=${importMode}&dry_run=${dryRun}`, {
+                method: 'POST',
+                headers: {'Content-Type': format === 'csv' ? 'text/csv' : 'application/json', 'X-Requested-With': 'XMLHttpRequest'},
+                body: await importFile.text(),
+            });
+            const data = await res.json();
+            if (!res.ok && !data?.errors) {
+                throw new Error(data?.error || res.statusText);
+            }
+            setImportResult(data);
+            setError(null);
+            if (data.applied) {
+                setImportFile(null);
+                await fetchMappings();
+            }
+        } catch (err) {
+            setError(`Failed to import mappings: ${(err as Error).message}`);
+        }
+    };
+
     // Fetch GitHub contributors and MM users
     useEffect(() => {
         const fetchData = async () => {
@@ -120,29 +266,67 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
         fetchContribs();
     }, []);
 
-    // Update parent when mappings change
-    const updateMappings = useCallback((newMappings: Record<string, string>) => {
+    // Save each change to the plugin's mapping store right away. The save carries
+    // the revision the page loaded, so changes made elsewhere since (a user
+    // linking an account, another admin) are reloaded instead of overwritten.
+    const updateMappings = useCallback(async (newMappings: Record<string, UserMapping>, newUnresolved = unresolved) => {
+        const previous = mappings;
+        const previousUnresolved = unresolved;
         setMappings(newMappings);
-        onChange(id, JSON.stringify(newMappings));
-        setSaveNeeded();
-    }, [id, onChange, setSaveNeeded]);
+        setUnresolved(newUnresolved);
+        try {
+            const res = await fetch(`/plugins/${PLUGIN_ID}/api/v1/mappings`, {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-Requested-With': 'XMLHttpRequest'},
+                body: JSON.stringify({mappings: Object.values(newMappings), unresolved: newUnresolved, revision}),
+            });
+            if (res.status === 409) {
+                await fetchMappings();
+                setError('Mappings were changed elsewhere and have been reloaded. Apply your change again.');
+                return;
+            }
+            if (!res.ok) {
+                throw new Error(res.statusText);
+            }
+            const data = await res.json();
+            setRevision(data.revision);
+            setError(null);
+        } catch (err) {
+            setMappings(previous);
+            setUnresolved(previousUnresolved);
+            setError('Failed to save mappings');
+        }
+    }, [mappings, unresolved, revision, fetchMappings]);
 
     const addMapping = (ghLogin: string, mmId: string) => {
-        const newMappings = { ...mappings, [ghLogin]: mmId };
-        updateMappings(newMappings);
+        const ghUser = githubUsers.find(u => u.login.toLowerCase() === ghLogin.toLowerCase());
+        const mapping: UserMapping = ghLogin.includes('@') ?
+            { email: ghLogin.toLowerCase(), mm_user_id: mmId } :
+            { github_login: ghLogin, github_id: ghUser?.id, mm_user_id: mmId };
+        const newMappings = { ...mappings, [mappingKey(mapping)]: mapping };
+        // Mapping an account again settles its unresolved legacy entry
+        updateMappings(newMappings, unresolved.filter(u => u.key.toLowerCase() !== ghLogin.toLowerCase()));
         setActiveDropdown(null);
         setSearchGH('');
         setSearchMM('');
     };
 
-    const removeMapping = (ghLogin: string) => {
+    const setMappingPeriod = (key: string, field: 'valid_from' | 'valid_to', value: string) => {
+        const updated = { ...mappings[key], [field]: value || undefined };
         const newMappings = { ...mappings };
-        delete newMappings[ghLogin];
+        delete newMappings[key];
+        newMappings[mappingKey(updated)] = updated;
+        updateMappings(newMappings);
+    };
+
+    const removeMapping = (key: string) => {
+        const newMappings = { ...mappings };
+        delete newMappings[key];
         updateMappings(newMappings);
     };
 
     const filteredGHUsers = githubUsers
-        .filter(u => !mappings[u.login])
+        .filter(u => !mappings[u.login.toLowerCase()])
         .filter(u => u.login.toLowerCase().includes(searchGH.toLowerCase()));
 
     const filteredMMUsers = mmUsers
@@ -181,7 +365,7 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
     };
 
     // Check if contributor is already mapped
-    const isMapped = (login: string) => !!mappings[login];
+    const isMapped = (login: string) => Object.values(mappings).some(m => mappingIdentity(m) === login.toLowerCase());
 
     if (loading) {
         return <div className="user-mappings-loading">Loading users...</div>;
@@ -196,16 +380,18 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
 
             {/* Existing mappings */}
             <div className="user-mappings-list">
-                {Object.entries(mappings).map(([ghLogin, mmId]) => {
-                    const ghUser = getGHUser(ghLogin);
+                {Object.entries(mappings).map(([key, mapping]) => {
+                    const mmId = mapping.mm_user_id;
+                    const ghLogin = mapping.github_login || '';
+                    const ghUser = ghLogin ? getGHUser(ghLogin) : undefined;
                     const mmUser = getMMUser(mmId);
                     return (
-                        <div key={ghLogin} className="user-mapping-row">
+                        <div key={key} className="user-mapping-row">
                             <div className="user-mapping-gh">
                                 {ghUser?.avatar_url && (
                                     <img src={ghUser.avatar_url} alt="" className="user-avatar-small" />
                                 )}
-                                <span className="user-login">@{ghLogin}</span>
+                                <span className="user-login">{ghLogin ? `@${ghLogin}` : mapping.email}</span>
                             </div>
                             <span className="mapping-arrow">→</span>
                             <div className="user-mapping-mm">
@@ -218,11 +404,37 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
                                     {mmUser && getMMUserBadge(mmUser)} {mmUser ? getMMDisplayName(mmUser) : mmId}
                                 </span>
                                 <span className="user-username">@{mmUser?.username}</span>
+                                {mapping.source === 'profile' && (
+                                    <span
+                                        className="mapping-source"
+                                        title="From the Mattermost profile. Removed mappings come back at the next sync; map the account explicitly to override it."
+                                    >
+                                        profile
+                                    </span>
+                                )}
+                            </div>
+                            <div
+                                className="user-mapping-period"
+                                title="Only commits authored in this period count for this user. Leave empty for no limit."
+                            >
+                                <input
+                                    type="date"
+                                    value={mapping.valid_from || ''}
+                                    max={mapping.valid_to}
+                                    onChange={(e) => setMappingPeriod(key, 'valid_from', e.target.value)}
+                                />
+                                <span>–</span>
+                                <input
+                                    type="date"
+                                    value={mapping.valid_to || ''}
+                                    min={mapping.valid_from}
+                                    onChange={(e) => setMappingPeriod(key, 'valid_to', e.target.value)}
+                                />
                             </div>
                             <button 
                                 type="button"
                                 className="mapping-remove-btn"
-                                onClick={() => removeMapping(ghLogin)}
+                                onClick={() => removeMapping(key)}
                             >
                                 ×
                             </button>
@@ -231,13 +443,172 @@ export const UserMappingsComponent: React.FC<UserMappingsProps> = ({
                 })}
             </div>
 
+            {/* Legacy entries the migration couldn't resolve */}
+            {unresolved.length > 0 && (
+                <div className="user-mappings-error">
+                    Some old mappings could not be migrated, map these accounts again:
+                    <ul>
+                        {unresolved.map(u => (
+                            <li key={u.key}>{u.key} → {u.value}: {u.reason}</li>
+                        ))}
+                    </ul>
+                </div>
+            )}
+
+            {/* Suggested mappings */}
+            <div className="mapping-suggestions">
+                <button
+                    type="button"
+                    className="btn btn-tertiary btn-sm"
+                    onClick={fetchSuggestions}
+                    disabled={loadingSuggestions}
+                >
+                    {loadingSuggestions ? 'Finding matches...' : 'Suggest mappings'}
+                </button>
+                {suggestions && suggestions.length === 0 && (
+                    <p className="user-mappings-help">No suggestions for unmapped commit authors.</p>
+                )}
+                {suggestions && suggestions.length > 0 && (
+                    <>
+                        <table className="contributors-table">
+                            <thead>
+                                <tr>
+                                    <th />
+                                    <th>GitHub User</th>
+                                    <th>Mattermost User</th>
+                                    <th>Confidence</th>
+                                </tr>
+                            </thead>
+                            <tbody>
+                                {suggestions.map(s => (
+                                    <tr key={s.github_login}>
+                                        <td>
+                                            <input
+                                                type="checkbox"
+                                                checked={!!selectedSuggestions[s.github_login]}
+                                                onChange={(e) => setSelectedSuggestions({
+                                                    ...selectedSuggestions,
+                                                    [s.github_login]: e.target.checked,
+                                                })}
+                                            />
+                                        </td>
+                                        <td>@{s.github_login}</td>
+                                        <td>@{s.mm_username}</td>
+                                        <td title={s.reasons.join(', ')}>
+                                            {Math.round(s.confidence * 100)}%
+                                            {s.ambiguous && <span className="suggestion-ambiguous"> (ambiguous)</span>}
+                                        </td>
+                                    </tr>
+                                ))}
+                            </tbody>
+                        </table>
+                        <button
+                            type="button"
+                            className="btn btn-primary btn-sm"
+                            onClick={acceptSuggestions}
+                            disabled={!Object.values(selectedSuggestions).some(Boolean)}
+                        >
+                            Accept selected
+                        </button>
+                    </>
+                )}
+            </div>
+
+            {/* Import and export */}
+            <div className="mapping-import">
+                <a
+                    className="btn btn-tertiary btn-sm"
+                    href={`/plugins/${PLUGIN_ID}/api/v1/mappings/export?format=csv`}
+                >
+                    Export CSV
+                </a>
+                <a
+                    className="btn btn-tertiary btn-sm"
+                    href={`/plugins/${PLUGIN_ID}/api/v1/mappings/export?format=json`}
+                >
+                    Export JSON
+                </a>
+                <input
+                    type="file"
+                    accept=".csv,.json,text/csv,application/json"
+                    onChange={(e) => {
+                        setImportFile(e.target.files?.[0] || null);
+                        setImportResult(null);
+                    }}
+                />
+                <select
+                    value={importMode}
+                    onChange={(e) => {
+                        setImportMode(e.target.value as 'replace' | 'merge');
+                        setImportResult(null);
+                    }}
+                >
+                    <option value="replace">Replace all mappings</option>
+                    <option value="merge">Add and update only</option>
+                </select>
+                <button
+                    type="button"
+                    className="btn btn-tertiary btn-sm"
+                    onClick={() => importMappings(true)}
+                    disabled={!importFile}
+                >
+                    Preview import
+                </button>
+                {importResult && (
+                    <div className="mapping-import-result">
+                        {importResult.errors.length > 0 ? (
+                            <ul className="mapping-import-errors">
+                                {importResult.errors.map(e => (
+                                    <li key={`${e.line}-${e.identity}`}>
+                                        Line {e.line}{e.identity ? ` (${e.identity})` : ''}: {e.error}
+                                    </li>
+                                ))}
+                            </ul>
+                        ) : (
+                            <>
+                                <p className="user-mappings-help">
+                                    {importResult.applied ? 'Imported' : 'Would import'}:{' '}
+                                    {importResult.created} new, {importResult.updated} changed, {importResult.deleted} removed
+                                </p>
+                                {!importResult.applied && importResult.changes.length > 0 && (
+                                    <>
+                                        <ul>
+                                            {importResult.changes.map(c => (
+                                                <li key={`${c.action}|${mappingKey((c.after || c.before)!)}`}>
+                                                    {c.action} {c.identity}
+                                                    {c.before && ` (was ${getMMUser(c.before.mm_user_id)?.username || c.before.mm_user_id})`}
+                                                    {c.after && ` → ${getMMUser(c.after.mm_user_id)?.username || c.after.mm_user_id}`}
+                                                </li>
+                                            ))}
+                                        </ul>
+                                        <button
+                                            type="button"
+                                            className="btn btn-primary btn-sm"
+                                            onClick={() => importMappings(false)}
+                                        >
+                                            Apply import
+                                        </button>
+                                    </>
+                                )}
+                            </>
+                        )}
+                    </div>
+                )}
+            </div>
+
             {/* Add new mapping */}
+            {searchGH.includes('@') && (
+                <p className="user-mappings-help">
+                    Email mappings only apply to commits without a GitHub account. Commits
+                    linked to an account count for its login, so map the login as well.
+                </p>
+            )}
             <div className="user-mapping-add">
                 <div className="mapping-dropdown-container">
                     <div className="mapping-dropdown">
                         <input
                             type="text"
-                            placeholder="GitHub username..."
+                            placeholder="GitHub username or commit email..."
                             value={searchGH}
                             onChange={(e) => {
                                 setSearchGH(e.target.value);
@@ -1,5 +1,5 @@
 import React from 'react';
-import GitHubReportsRHS from './components/rhs';
+import GitHubReportsRHS, {STATS_UPDATED_EVENT} from './components/rhs';
 import UserMappingsComponent from './components/user_mappings';
 import RepositoriesInput from './components/repositories_input';
 
@@ -25,6 +25,12 @@ export default class Plugin {
             'GitHub Activity'
         );
 
+        // Fresher current-week stats were stored; let an open RHS refetch
+        registry.registerWebSocketEventHandler(
+            'custom_com.fambear.github-reports_stats_updated',
+            (msg: any) => window.dispatchEvent(new CustomEvent(STATS_UPDATED_EVENT, {detail: msg.data}))
+        );
+
         // Register channel header button to toggle RHS
         registry.registerChannelHeaderButtonAction(
             <ReportsIcon />,